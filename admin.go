@@ -0,0 +1,80 @@
+package estelle
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// PendingTask is a task queued or running, reported by Estelle.Pending.
+type PendingTask struct {
+	Info       ThumbInfo
+	State      JobState // JobQueued or JobRunning
+	Priority   Priority
+	Client     string // See WithClient
+	EnqueuedAt time.Time
+}
+
+// Pending returns the tasks queued or running at the moment, in the order they were enqueued.
+func (estl *Estelle) Pending() []PendingTask {
+	p := estl.pendingTasks.Load()
+	if p == nil {
+		return nil
+	}
+	var tasks []PendingTask
+	p.IterCb(func(_ string, res *Result) {
+		if isClosed(res.done) {
+			return
+		}
+		t := PendingTask{
+			Info:       res.ti,
+			State:      JobQueued,
+			Priority:   estl.runner.Priority(res.job),
+			Client:     res.job.client,
+			EnqueuedAt: res.enqueuedAt,
+		}
+		if res.running() {
+			t.State = JobRunning
+		}
+		tasks = append(tasks, t)
+	})
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].EnqueuedAt.Before(tasks[j].EnqueuedAt) })
+	return tasks
+}
+
+// SetCacheLimit changes the cache size limit and the watermark ratios of the garbage collector,
+// which are initially set by WithCacheLimit and WithGCRatio.
+// Garbage collection starts immediately if the cache exceeds the new high watermark.
+func (estl *Estelle) SetCacheLimit(limit int64, high, low float64) error {
+	if limit <= 0 {
+		return fmt.Errorf("invalid cache limit: %d", limit)
+	}
+	if !(0 < low && low <= high && high <= 1) {
+		return fmt.Errorf("invalid GC ratios: high=%g, low=%g", high, low)
+	}
+	estl.gc.SetLimit(limit, high, low)
+	return nil
+}
+
+// CacheLimit returns the cache size limit and the watermark ratios of the garbage collector.
+func (estl *Estelle) CacheLimit() (limit int64, high, low float64) {
+	return estl.gc.Limit()
+}
+
+// CollectGarbage triggers garbage collection, which removes old thumbnails until the cache
+// gets below the low watermark, even if it does not exceed the high watermark.
+// It returns immediately without waiting for the collection to finish.
+func (estl *Estelle) CollectGarbage() {
+	estl.gc.Collect()
+}
+
+// SetWorkers changes the number of workers set by WithWorkers. When it decreases, the extra
+// workers exit after finishing their current tasks. It returns ErrEstelleClosed after Shutdown.
+func (estl *Estelle) SetWorkers(n int) error {
+	return estl.runner.SetWorkers(n)
+}
+
+// Workers returns the number of workers.
+func (estl *Estelle) Workers() int {
+	return estl.runner.Workers()
+}
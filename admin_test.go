@@ -0,0 +1,127 @@
+package estelle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSetWorkers(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg", "c.jpg")
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	var results []*Result
+	for i, src := range srcs {
+		ti, _ := estl.NewThumbInfo(src, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+		res, err := estl.Enqueue(ti, WithClient("alice"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+		if i == 0 {
+			<-gen.started // Occupy the only worker
+		}
+	}
+
+	pending := estl.Pending()
+	if len(pending) != 3 {
+		t.Fatalf("expected 3 pending tasks, got %d", len(pending))
+	}
+	if p := pending[0]; p.State != JobRunning || p.Info.Source() != srcs[0] || p.Client != "alice" || p.Priority != PriorityInteractive {
+		t.Errorf("unexpected pending task: %+v", p)
+	}
+	if p := pending[2]; p.State != JobQueued || p.Info.Source() != srcs[2] {
+		t.Errorf("unexpected pending task: %+v", p)
+	}
+
+	// The queued tasks start as soon as workers are added.
+	if err := estl.SetWorkers(3); err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		select {
+		case <-gen.started:
+		case <-time.After(time.Second):
+			t.Fatal("added workers did not start the queued tasks")
+		}
+	}
+	if st := estl.Stats(); st.Running != 3 || estl.Workers() != 3 {
+		t.Errorf("expected 3 running with 3 workers, got %d with %d", st.Running, estl.Workers())
+	}
+
+	if err := estl.SetWorkers(1); err != nil {
+		t.Fatal(err)
+	}
+	close(gen.unblock)
+	for _, res := range results {
+		<-res.Done()
+	}
+	if len(estl.Pending()) != 0 {
+		t.Errorf("expected no pending tasks, got %v", estl.Pending())
+	}
+	estl.Shutdown(context.Background())
+	if err := estl.SetWorkers(2); err != ErrEstelleClosed {
+		t.Errorf("expected ErrEstelleClosed, got %v", err)
+	}
+}
+
+func TestSetCacheLimit(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	// 10 files of 1000 bytes, created before the initial scan of GC
+	for i := range 10 {
+		dir := filepath.Join(cacheDir, "00", string(rune('a'+i))+"0")
+		os.MkdirAll(dir, 0755)
+		os.WriteFile(filepath.Join(dir, "thumb.jpg"), make([]byte, 1000), 0644)
+	}
+	estl, err := New(cacheDir, WithCacheLimit(100_000), WithGCRatio(0.9, 0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	for _, args := range [][3]float64{{0, 0.9, 0.5}, {1000, 0.5, 0.9}, {1000, 1.1, 0.5}, {1000, 0.9, 0}} {
+		if err := estl.SetCacheLimit(int64(args[0]), args[1], args[2]); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+
+	waitUsage := func(cond func(int64) bool) int64 {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			used := estl.Stats().CacheBytes
+			if cond(used) {
+				return used
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("unexpected cache usage: %d", used)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	estl.gc.Track(0) // Wait for the initial scan
+	waitUsage(func(used int64) bool { return used == 10_000 })
+
+	// Lower the limit so that GC starts
+	if err := estl.SetCacheLimit(10_000, 0.8, 0.6); err != nil {
+		t.Fatal(err)
+	}
+	if limit, high, low := estl.CacheLimit(); limit != 10_000 || high != 0.8 || low != 0.6 {
+		t.Errorf("unexpected limit: %d, %g, %g", limit, high, low)
+	}
+	waitUsage(func(used int64) bool { return used <= 6_000 })
+
+	// Forced GC goes down to the low watermark, even below the high watermark.
+	estl.SetCacheLimit(10_000, 0.8, 0.3)
+	estl.CollectGarbage()
+	waitUsage(func(used int64) bool { return used <= 3_000 })
+}
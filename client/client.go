@@ -0,0 +1,179 @@
+//go:build unix
+
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/Maki-Daisuke/estelle/v2"
+)
+
+// maxResponseSize is the limit of the response read from estelled.
+// Responses of /fd have no body except for errors, so this is enough.
+const maxResponseSize = 1 << 20
+
+// Client receives thumbnails from estelled listening on a unix domain socket.
+type Client struct {
+	Socket string // Path to the unix domain socket, i.e. ESTELLE_ADDR without "unix:"
+	Key    string // Secret key (ESTELLE_SECRET), if any
+}
+
+// Thumbnail is a thumbnail received from estelled.
+type Thumbnail struct {
+	File        *os.File // The thumbnail opened read-only. The caller must close it.
+	ContentType string   // MIME type of the thumbnail
+	Width       int      // Actual width of the thumbnail, or 0 if unknown
+	Height      int      // Actual height of the thumbnail, or 0 if unknown
+}
+
+// Error is returned when estelled responds with an error.
+type Error struct {
+	StatusCode int    // HTTP status code
+	Code       string // Machine-readable error code, e.g. "unsupported_format". Empty if unavailable.
+	Message    string // Human-readable description
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("estelled: %d %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("estelled: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Open requests the thumbnail of source and receives its file descriptor.
+// Like /get, it blocks until the thumbnail is generated, or ctx is done.
+func (c *Client) Open(ctx context.Context, source string, size estelle.Size, mode estelle.Mode, format estelle.Format) (*Thumbnail, error) {
+	query := url.Values{}
+	query.Set("source", source)
+	query.Set("size", size.String())
+	query.Set("mode", mode.String())
+	query.Set("format", format.String())
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://estelled/fd?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if c.Key != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+	}
+	req.Close = true // The server closes the connection after sending the descriptor anyway.
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "unix", c.Socket)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// Interrupt the blocking reads and writes below when ctx is done.
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	if err := req.Write(conn); err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	data, fds, err := readAll(conn.(*net.UnixConn))
+	if err != nil {
+		closeAll(fds)
+		return nil, ctxErr(ctx, err)
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		closeAll(fds)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		closeAll(fds)
+		return nil, responseError(resp)
+	}
+	if len(fds) != 1 {
+		closeAll(fds)
+		return nil, fmt.Errorf("estelled: expected a file descriptor, but received %d", len(fds))
+	}
+	thumb := &Thumbnail{
+		File:        os.NewFile(uintptr(fds[0]), "thumbnail:"+source),
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+	thumb.Width, _ = strconv.Atoi(resp.Header.Get("X-Thumb-Width"))
+	thumb.Height, _ = strconv.Atoi(resp.Header.Get("X-Thumb-Height"))
+	return thumb, nil
+}
+
+// readAll reads conn until EOF, and returns the data and the file descriptors received with it.
+func readAll(conn *net.UnixConn) ([]byte, []int, error) {
+	var data bytes.Buffer
+	var fds []int
+	buf := make([]byte, 4096)
+	// The server sends exactly one descriptor, but leave room for more to detect misbehavior.
+	oob := make([]byte, syscall.CmsgSpace(16*4))
+	for {
+		n, oobn, flags, _, err := conn.ReadMsgUnix(buf, oob)
+		data.Write(buf[:n])
+		if oobn > 0 {
+			msgs, perr := syscall.ParseSocketControlMessage(oob[:oobn])
+			if perr != nil {
+				return nil, fds, perr
+			}
+			for _, msg := range msgs {
+				if rights, err := syscall.ParseUnixRights(&msg); err == nil {
+					fds = append(fds, rights...)
+				}
+			}
+		}
+		if flags&syscall.MSG_CTRUNC != 0 {
+			// Some descriptors are lost, and what has been received may not be the right one.
+			return nil, fds, errors.New("estelled: control message is truncated")
+		}
+		if err == io.EOF || err == nil && n == 0 && oobn == 0 {
+			return data.Bytes(), fds, nil
+		}
+		if err != nil {
+			return nil, fds, err
+		}
+		if data.Len() > maxResponseSize {
+			return nil, fds, errors.New("estelled: response is too large")
+		}
+	}
+}
+
+// responseError converts an error response into *Error.
+func responseError(resp *http.Response) error {
+	e := &Error{StatusCode: resp.StatusCode}
+	body, _ := io.ReadAll(resp.Body)
+	var eb struct {
+		Error   string `json:"error"`
+		Message string `json:"message"`
+	}
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") && json.Unmarshal(body, &eb) == nil {
+		e.Code, e.Message = eb.Error, eb.Message
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+	return e
+}
+
+// ctxErr returns the error of ctx if it is done, since err is then caused by closing the connection.
+func ctxErr(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func closeAll(fds []int) {
+	for _, fd := range fds {
+		syscall.Close(fd)
+	}
+}
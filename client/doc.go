@@ -0,0 +1,6 @@
+// Package client is a helper to receive thumbnails from estelled over a unix domain socket.
+//
+// Instead of the path of the thumbnail, estelled sends the file descriptor of it via the /fd
+// endpoint, so that the clients do not need any access to the cache directory, e.g. when they
+// run in a sandbox. This is supported only on unix-like platforms.
+package client
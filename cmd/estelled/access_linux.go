@@ -0,0 +1,162 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// POSIX ACL tags and permissions in the system.posix_acl_access extended attribute.
+const (
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+
+	permRead = 4
+	permExec = 1
+)
+
+type aclEntry struct {
+	tag  uint16
+	perm uint16
+	id   uint32
+}
+
+// peerCanRead checks if the peer process could open path for reading, that is, it has read
+// permission on path and search permission on all its ancestor directories. Both permission
+// bits and POSIX ACLs are taken into account. path must not contain symbolic links.
+func peerCanRead(cred peerCred, path string) error {
+	if cred.UID == 0 {
+		return nil // root can read anything
+	}
+	groups := append([]uint32{cred.GID}, cred.Groups...)
+	dirs := []string{}
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := checkAccess(cred.UID, groups, dirs[i], permExec); err != nil {
+			return err
+		}
+	}
+	return checkAccess(cred.UID, groups, path, permRead)
+}
+
+// checkAccess checks if the user with uid and groups has the permission want on path,
+// in the same way as the kernel does.
+func checkAccess(uid uint32, groups []uint32, path string, want uint16) error {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+	acl, err := readACL(path)
+	if err != nil {
+		return err
+	}
+	if acl == nil {
+		// Without ACL, the permission bits are equivalent to the minimal ACL.
+		acl = []aclEntry{
+			{tag: aclUserObj, perm: uint16(st.Mode>>6) & 7},
+			{tag: aclGroupObj, perm: uint16(st.Mode>>3) & 7},
+			{tag: aclOther, perm: uint16(st.Mode) & 7},
+		}
+	}
+	if !aclPermits(acl, st.Uid, st.Gid, uid, groups, want) {
+		return &os.PathError{Op: "access", Path: path, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// aclPermits evaluates acl of a file owned by owner and group according to POSIX.1e.
+func aclPermits(acl []aclEntry, owner, group uint32, uid uint32, groups []uint32, want uint16) bool {
+	mask := uint16(7)
+	for _, e := range acl {
+		if e.tag == aclMask {
+			mask = e.perm
+		}
+	}
+	if uid == owner {
+		for _, e := range acl {
+			if e.tag == aclUserObj {
+				return e.perm&want == want
+			}
+		}
+		return false
+	}
+	for _, e := range acl {
+		if e.tag == aclUser && e.id == uid {
+			return e.perm&mask&want == want
+		}
+	}
+	matched := false
+	for _, e := range acl {
+		var gid uint32
+		switch e.tag {
+		case aclGroupObj:
+			gid = group
+		case aclGroup:
+			gid = e.id
+		default:
+			continue
+		}
+		for _, g := range groups {
+			if g == gid {
+				if e.perm&mask&want == want {
+					return true
+				}
+				matched = true
+			}
+		}
+	}
+	if matched {
+		return false
+	}
+	for _, e := range acl {
+		if e.tag == aclOther {
+			return e.perm&want == want
+		}
+	}
+	return false
+}
+
+// readACL reads the access ACL of path. It returns nil if path has no extended ACL.
+func readACL(path string) ([]aclEntry, error) {
+	buf := make([]byte, 1024)
+	n, err := syscall.Getxattr(path, "system.posix_acl_access", buf)
+	if errors.Is(err, syscall.ERANGE) {
+		if n, err = syscall.Getxattr(path, "system.posix_acl_access", nil); err == nil {
+			buf = make([]byte, n)
+			n, err = syscall.Getxattr(path, "system.posix_acl_access", buf)
+		}
+	}
+	if errors.Is(err, syscall.ENODATA) || errors.Is(err, syscall.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: err}
+	}
+	buf = buf[:n]
+	// Header: version (u32, little endian) = 2, followed by entries of tag (u16), perm (u16) and id (u32).
+	if len(buf) < 4 || binary.LittleEndian.Uint32(buf) != 2 || (len(buf)-4)%8 != 0 {
+		return nil, &os.PathError{Op: "getxattr", Path: path, Err: errors.New("malformed ACL")}
+	}
+	var acl []aclEntry
+	for b := buf[4:]; len(b) > 0; b = b[8:] {
+		acl = append(acl, aclEntry{
+			tag:  binary.LittleEndian.Uint16(b),
+			perm: binary.LittleEndian.Uint16(b[2:]),
+			id:   binary.LittleEndian.Uint32(b[4:]),
+		})
+	}
+	return acl, nil
+}
@@ -0,0 +1,160 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestACLPermits(t *testing.T) {
+	acl := []aclEntry{
+		{tag: aclUserObj, perm: 6},
+		{tag: aclUser, perm: 4, id: 1001},
+		{tag: aclUser, perm: 4, id: 1002},
+		{tag: aclGroupObj, perm: 0},
+		{tag: aclGroup, perm: 4, id: 2001},
+		{tag: aclMask, perm: 4},
+		{tag: aclOther, perm: 0},
+	}
+	const owner, group = 1000, 2000
+	tests := []struct {
+		uid    uint32
+		groups []uint32
+		want   bool
+	}{
+		{1000, nil, true},                   // Owner
+		{1001, nil, true},                   // Named user
+		{1003, []uint32{2001}, true},        // Named group
+		{1003, []uint32{2000}, false},       // Owning group without permission
+		{1003, []uint32{2000, 2001}, true},  // Any of the matching groups
+		{1003, []uint32{3000}, false},       // Other
+		{1002, []uint32{2001}, true},        // Named user wins
+		{1004, []uint32{2000, 3000}, false}, // Matched group without permission does not fall back to other
+	}
+	for _, tt := range tests {
+		if got := aclPermits(acl, owner, group, tt.uid, tt.groups, permRead); got != tt.want {
+			t.Errorf("uid=%d groups=%v: expected %v, got %v", tt.uid, tt.groups, tt.want, got)
+		}
+	}
+
+	// The mask limits the named entries, but not the owner and others.
+	masked := []aclEntry{{tag: aclUserObj, perm: 4}, {tag: aclUser, perm: 4, id: 1001}, {tag: aclMask, perm: 0}, {tag: aclOther, perm: 4}}
+	if aclPermits(masked, owner, group, 1001, nil, permRead) {
+		t.Error("named user should be masked")
+	}
+	if !aclPermits(masked, owner, group, 1000, nil, permRead) || !aclPermits(masked, owner, group, 1005, nil, permRead) {
+		t.Error("owner and others should not be masked")
+	}
+}
+
+func TestPeerAccess(t *testing.T) {
+	root := t.TempDir()
+	os.Chmod(filepath.Dir(root), 0755)
+	os.Chmod(root, 0755)
+	private := filepath.Join(root, "private")
+	os.Mkdir(private, 0700)
+	public := filepath.Join(root, "public.jpg")
+	os.WriteFile(public, []byte("image"), 0644)
+	secret := filepath.Join(root, "secret.jpg")
+	os.WriteFile(secret, []byte("image"), 0600)
+	inPrivate := filepath.Join(private, "a.jpg")
+	os.WriteFile(inPrivate, []byte("image"), 0644)
+
+	d, err := parseAllowedDir(root + "=within,peer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowedDirs = []allowedDir{d}
+
+	// Neither the owner nor root, unless running as the same uid.
+	stranger := peerCred{UID: 54321, GID: 54321, PID: -1}
+	if uint32(os.Getuid()) == stranger.UID {
+		t.Skip("running as the test uid")
+	}
+	ctx := context.WithValue(context.Background(), ctxKeyPeerCred{}, stranger)
+	tests := map[string]bool{public: true, secret: false, inPrivate: false}
+	for source, want := range tests {
+		_, err := confine(ctx, source)
+		var he HTTPError
+		if want && err != nil {
+			t.Errorf("%s: expected allowed, got %v", source, err)
+		}
+		if !want && (!errors.As(err, &he) || he.code != http.StatusForbidden) {
+			t.Errorf("%s: expected 403, got %v", source, err)
+		}
+	}
+
+	// Supplementary groups are those recorded for the connection, not those of the user.
+	grouped := filepath.Join(root, "grouped.jpg")
+	os.WriteFile(grouped, []byte("image"), 0640)
+	if err := os.Chown(grouped, os.Getuid(), 54322); err == nil {
+		member := stranger
+		member.Groups = []uint32{54322}
+		if _, err := confine(context.WithValue(context.Background(), ctxKeyPeerCred{}, member), grouped); err != nil {
+			t.Errorf("expected allowed for a member of the group, got %v", err)
+		}
+		if _, err := confine(ctx, grouped); err == nil {
+			t.Error("expected to be denied for a non-member of the group")
+		}
+	}
+
+	// The owner can read its own files.
+	owner := context.WithValue(context.Background(), ctxKeyPeerCred{}, peerCred{UID: uint32(os.Getuid()), GID: uint32(os.Getgid()), PID: int32(os.Getpid())})
+	for source := range tests {
+		if _, err := confine(owner, source); err != nil {
+			t.Errorf("%s: expected allowed for the owner, got %v", source, err)
+		}
+	}
+
+	// Without peer credentials, e.g. via TCP
+	if _, err := confine(context.Background(), public); err == nil {
+		t.Error("expected to be denied without peer credentials")
+	}
+}
+
+func TestGetPeerCred(t *testing.T) {
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	client, err := net.Dial("unix", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	cred, err := getPeerCred(server.(*net.UnixConn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cred.UID != uint32(os.Getuid()) || cred.GID != uint32(os.Getgid()) || cred.PID != int32(os.Getpid()) {
+		t.Errorf("unexpected credentials: %+v", cred)
+	}
+	gids, err := os.Getgroups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := make([]uint32, len(gids))
+	for i, g := range gids {
+		want[i] = uint32(g)
+	}
+	got := slices.Clone(cred.Groups)
+	slices.Sort(got)
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Errorf("expected groups %v, got %v", want, got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// peerCanRead is not supported on non-Linux systems, where peer credentials are not available.
+func peerCanRead(cred peerCred, path string) error {
+	return errors.New("access check of the peer is not supported on this platform")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"time"
+)
+
+// withAdminAPI serves the admin API under /admin/ in addition to next. The admin API is
+// authenticated by ESTELLE_ADMIN_SECRET instead of ESTELLE_SECRET, and disabled if it is empty.
+func withAdminAPI(next http.Handler) http.Handler {
+	if config.AdminSecret == "" {
+		return next
+	}
+	api := http.NewServeMux()
+	api.HandleFunc("GET /admin/config", handleAdminConfig)
+	api.HandleFunc("GET /admin/tasks", handleAdminTasks)
+	api.HandleFunc("POST /admin/gc", handleAdminGC)
+	api.HandleFunc("PUT /admin/cache", handleAdminCache)
+	api.HandleFunc("PUT /admin/workers", handleAdminWorkers)
+
+	mux := http.NewServeMux()
+	mux.Handle("/", next)
+	mux.Handle("/admin/", withAuth(api, config.AdminSecret, nil))
+	return mux
+}
+
+// handleAdminConfig returns the effective configuration as a JSON object keyed by the names of
+// the environment variables. Secrets are redacted, and the settings changeable at runtime are
+// reported with their current values.
+func handleAdminConfig(res http.ResponseWriter, req *http.Request) {
+	cfg := map[string]any{}
+	v := reflect.ValueOf(config)
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		val := v.Field(i).Interface()
+		if field.Tag.Get("secret") == "true" && val != "" {
+			val = "REDACTED"
+		}
+		if d, ok := val.(time.Duration); ok {
+			val = d.String()
+		}
+		cfg[field.Tag.Get("env")] = val
+	}
+	limit, high, low := estelle.CacheLimit()
+	cfg["ESTELLE_CACHE_LIMIT"] = limit
+	cfg["ESTELLE_GC_HIGH_RATIO"] = high
+	cfg["ESTELLE_GC_LOW_RATIO"] = low
+	cfg["ESTELLE_WORKERS"] = estelle.Workers()
+	writeJSON(res, http.StatusOK, cfg)
+}
+
+// adminTask is an element of the /admin/tasks response.
+type adminTask struct {
+	ID         string    `json:"id"`
+	Source     string    `json:"source"`
+	State      string    `json:"state"` // "queued" or "running"
+	Priority   string    `json:"priority"`
+	Client     string    `json:"client"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+}
+
+// handleAdminTasks lists the queued and running tasks in the order they were enqueued.
+func handleAdminTasks(res http.ResponseWriter, req *http.Request) {
+	tasks := []adminTask{}
+	for _, t := range estelle.Pending() {
+		tasks = append(tasks, adminTask{
+			ID:         t.Info.String(),
+			Source:     t.Info.Source(),
+			State:      t.State.String(),
+			Priority:   t.Priority.String(),
+			Client:     t.Client,
+			EnqueuedAt: t.EnqueuedAt,
+		})
+	}
+	writeJSON(res, http.StatusOK, tasks)
+}
+
+// handleAdminGC triggers garbage collection, which runs in the background.
+func handleAdminGC(res http.ResponseWriter, req *http.Request) {
+	estelle.CollectGarbage()
+	writeJSON(res, http.StatusAccepted, map[string]int64{"cache_bytes": estelle.Stats().CacheBytes})
+}
+
+// cacheSettings is the body of PUT /admin/cache and its response.
+// Omitted fields in the request are left unchanged.
+type cacheSettings struct {
+	Limit     string  `json:"limit,omitempty"` // e.g. "500MB"
+	HighRatio float64 `json:"high_ratio,omitempty"`
+	LowRatio  float64 `json:"low_ratio,omitempty"`
+}
+
+// handleAdminCache changes the cache limit and the GC ratios.
+func handleAdminCache(res http.ResponseWriter, req *http.Request) {
+	var body cacheSettings
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 4096)).Decode(&body); err != nil {
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "body must be a JSON object of {limit, high_ratio, low_ratio}: " + err.Error()})
+		return
+	}
+	limit, high, low := estelle.CacheLimit()
+	if body.Limit != "" {
+		n, err := parseBytes(body.Limit)
+		if err != nil {
+			writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "invalid limit: " + body.Limit})
+			return
+		}
+		limit = n
+	}
+	if body.HighRatio != 0 {
+		high = body.HighRatio
+	}
+	if body.LowRatio != 0 {
+		low = body.LowRatio
+	}
+	if err := estelle.SetCacheLimit(limit, high, low); err != nil {
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: err.Error()})
+		return
+	}
+	limit, high, low = estelle.CacheLimit()
+	writeJSON(res, http.StatusOK, map[string]any{"limit": limit, "high_ratio": high, "low_ratio": low})
+}
+
+// handleAdminWorkers changes the number of workers.
+func handleAdminWorkers(res http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Workers int `json:"workers"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, 4096)).Decode(&body); err != nil || body.Workers < 1 {
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "body must be a JSON object of {workers} with a positive number"})
+		return
+	}
+	if err := estelle.SetWorkers(body.Workers); err != nil {
+		writeError(res, req, err)
+		return
+	}
+	writeJSON(res, http.StatusOK, map[string]int{"workers": estelle.Workers()})
+}
+
+func writeJSON(res http.ResponseWriter, status int, body any) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(body)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestAdminAPI(t *testing.T) {
+	var err error
+	estelle, err = New(filepath.Join(t.TempDir(), "cache"), WithGenerator(GoGenerator{}), WithWorkers(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estelle.Shutdown(context.Background())
+	saved := config
+	defer func() { config = saved }()
+	config.Secret = "user-secret"
+	config.AdminSecret = "admin-secret"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /hello", func(res http.ResponseWriter, req *http.Request) {})
+	handler := withAdminAPI(withAuth(mux, config.Secret, nil))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(method, target, strings.NewReader(body)))
+		return rr
+	}
+
+	// Separately authenticated
+	for target, want := range map[string]int{
+		"/hello?key=user-secret":         http.StatusOK,
+		"/hello?key=admin-secret":        http.StatusForbidden,
+		"/admin/config?key=user-secret":  http.StatusForbidden,
+		"/admin/config":                  http.StatusForbidden,
+		"/admin/config?key=admin-secret": http.StatusOK,
+	} {
+		if rr := do("GET", target, ""); rr.Code != want {
+			t.Errorf("%s: expected %d, got %d", target, want, rr.Code)
+		}
+	}
+
+	var cfg map[string]any
+	json.Unmarshal(do("GET", "/admin/config?key=admin-secret", "").Body.Bytes(), &cfg)
+	if cfg["ESTELLE_SECRET"] != "REDACTED" || cfg["ESTELLE_ADMIN_SECRET"] != "REDACTED" {
+		t.Errorf("secrets must be redacted: %v", cfg)
+	}
+	if cfg["ESTELLE_WORKERS"] != 2.0 || cfg["ESTELLE_CACHE_LIMIT"] != float64(1<<30) {
+		t.Errorf("unexpected live settings: %v", cfg)
+	}
+
+	if rr := do("PUT", "/admin/cache?key=admin-secret", `{"limit": "10MB", "low_ratio": 0.5}`); rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if limit, high, low := estelle.CacheLimit(); limit != 10<<20 || high != 0.9 || low != 0.5 {
+		t.Errorf("unexpected cache limit: %d, %g, %g", limit, high, low)
+	}
+	for _, body := range []string{`{"low_ratio": 0.95}`, `{"limit": "lots"}`, `nope`} {
+		if rr := do("PUT", "/admin/cache?key=admin-secret", body); rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected 400, got %d", body, rr.Code)
+		}
+	}
+
+	if rr := do("PUT", "/admin/workers?key=admin-secret", `{"workers": 4}`); rr.Code != http.StatusOK || estelle.Workers() != 4 {
+		t.Errorf("expected 4 workers, got %d: %s", estelle.Workers(), rr.Body.String())
+	}
+	if rr := do("PUT", "/admin/workers?key=admin-secret", `{"workers": 0}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rr.Code)
+	}
+
+	if rr := do("GET", "/admin/tasks?key=admin-secret", ""); rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != "[]" {
+		t.Errorf("expected empty list, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := do("POST", "/admin/gc?key=admin-secret", ""); rr.Code != http.StatusAccepted {
+		t.Errorf("expected 202, got %d", rr.Code)
+	}
+
+	// Disabled without the admin secret
+	config.AdminSecret = ""
+	handler = withAdminAPI(withAuth(mux, config.Secret, nil))
+	if rr := do("GET", "/admin/config?key=user-secret", ""); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+const (
+	// maxBatchItems is the max number of items in a /batch request.
+	maxBatchItems = 1000
+	// maxBatchBody is the max size of a /batch request body.
+	maxBatchBody = 1 << 20
+)
+
+// batchItem is an item of a /batch request. The fields are the same as the query parameters of /get.
+type batchItem struct {
+	Source string `json:"source"`
+	Size   string `json:"size,omitempty"`
+	Mode   string `json:"mode,omitempty"`
+	Format string `json:"format,omitempty"`
+}
+
+// batchResult is the status of an item in a /batch response.
+type batchResult struct {
+	ID      string `json:"id,omitempty"`
+	State   string `json:"state"` // "done", "queued" or "failed"
+	Path    string `json:"path,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleBatch queues thumbnails for the JSON array of items, and returns their statuses in the
+// same order. Failures of some items, e.g. the queue getting full, don't affect the others.
+// With ?wait=DURATION, it blocks until all the items complete or the duration elapses.
+func handleBatch(res http.ResponseWriter, req *http.Request) {
+	var wait time.Duration
+	if s := req.URL.Query().Get("wait"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "wait must be a non-negative duration, e.g. 10s"})
+			return
+		}
+		wait = min(d, maxStatusWait)
+	}
+
+	var items []batchItem
+	if err := json.NewDecoder(http.MaxBytesReader(res, req.Body, maxBatchBody)).Decode(&items); err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			writeError(res, req, HTTPError{code: http.StatusRequestEntityTooLarge, msg: "request body is too large"})
+			return
+		}
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "body must be a JSON array of {source, size, mode, format}: " + err.Error()})
+		return
+	}
+	if len(items) > maxBatchItems {
+		writeError(res, req, HTTPError{code: http.StatusRequestEntityTooLarge, msg: fmt.Sprintf("too many items (max %d)", maxBatchItems)})
+		return
+	}
+
+	// Blocking batches are treated like /get, and non-blocking ones like /queue.
+	priority := PriorityBackground
+	if wait > 0 {
+		priority = PriorityInteractive
+	}
+	client := clientID(req)
+
+	results := make([]batchResult, len(items))
+	infos := make([]ThumbInfo, len(items))
+	tasks := make([]*Result, len(items))
+	for i, item := range items {
+		ti, err := thumbInfoFromQuery(req.Context(), url.Values{
+			"source": {item.Source},
+			"size":   {item.Size},
+			"mode":   {item.Mode},
+			"format": {item.Format},
+		})
+		if err != nil {
+			results[i] = failedBatchResult("", err)
+			continue
+		}
+		infos[i] = ti
+		tasks[i], err = estelle.Enqueue(ti, WithPriority(priority), WithClient(client))
+		if err != nil {
+			results[i] = failedBatchResult(ti.String(), err)
+		}
+	}
+
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+	Wait:
+		for _, task := range tasks {
+			if task == nil {
+				continue
+			}
+			select {
+			case <-task.Done():
+			case <-timer.C:
+				break Wait
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}
+
+	for i, task := range tasks {
+		if task == nil {
+			continue
+		}
+		select {
+		case <-task.Done():
+			if err := task.Err(); err != nil {
+				results[i] = failedBatchResult(infos[i].String(), err)
+				continue
+			}
+			meta := task.Metadata()
+			results[i] = batchResult{
+				ID:     infos[i].String(),
+				State:  JobDone.String(),
+				Path:   infos[i].Path(),
+				Width:  meta.Width,
+				Height: meta.Height,
+			}
+		default:
+			results[i] = batchResult{ID: infos[i].String(), State: JobQueued.String()}
+		}
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	json.NewEncoder(res).Encode(results)
+}
+
+func failedBatchResult(id string, err error) batchResult {
+	status, code, msg := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		slog.Error("Batch item failed", "id", id, errorAttr("error", err))
+	}
+	return batchResult{ID: id, State: JobFailed.String(), Error: code, Message: msg}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestHandleBatch(t *testing.T) {
+	tempCache, src := setupEstelle(t)
+
+	body := `[
+		{"source": "` + src + `", "size": "50x50", "mode": "shrink", "format": "png"},
+		{"source": "` + filepath.Join(tempCache, "missing.png") + `"},
+		{"source": "/etc/passwd"},
+		{"source": "` + src + `", "size": "20x20", "format": "webp"}
+	]`
+	rr := httptest.NewRecorder()
+	handleBatch(rr, httptest.NewRequest("POST", "/batch?wait=5s", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []batchResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(results))
+	}
+	if r := results[0]; r.State != "done" || r.Path == "" || r.Width != 50 || r.Height != 25 {
+		t.Errorf("unexpected result #0: %+v", r)
+	}
+	if r := results[1]; r.State != "failed" || r.Error != "not_found" {
+		t.Errorf("unexpected result #1: %+v", r)
+	}
+	if r := results[2]; r.State != "failed" || r.Error != "forbidden" {
+		t.Errorf("unexpected result #2: %+v", r)
+	}
+	if r := results[3]; r.State != "failed" || r.Error != "unsupported_format" || r.ID == "" {
+		t.Errorf("unexpected result #3: %+v", r)
+	}
+
+	for _, body := range []string{`{"source": "x"}`, `not json`} {
+		rr := httptest.NewRecorder()
+		handleBatch(rr, httptest.NewRequest("POST", "/batch", strings.NewReader(body)))
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("expected 400 for %q, got %d", body, rr.Code)
+		}
+	}
+}
+
+func TestHandleBatchQueueFull(t *testing.T) {
+	tempCache := t.TempDir()
+	var body []batchItem
+	for _, name := range []string{"a.jpg", "b.jpg", "c.jpg"} {
+		src := filepath.Join(tempCache, name)
+		os.WriteFile(src, []byte(name), 0644)
+		body = append(body, batchItem{Source: src, Format: "jpg"})
+	}
+	unblock := make(chan struct{})
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		select {
+		case <-unblock:
+			return os.WriteFile(output, []byte("thumbnail"), 0644)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	var errInit error
+	estelle, errInit = New(filepath.Join(tempCache, "cache"), WithGenerator(gen), WithWorkers(1), WithBufferSize(1))
+	if errInit != nil {
+		t.Fatal(errInit)
+	}
+	defer estelle.Shutdown(context.Background())
+	defer close(unblock) // Must be called before Shutdown
+	allowDirs(t, tempCache)
+
+	b, _ := json.Marshal(body)
+	rr := httptest.NewRecorder()
+	handleBatch(rr, httptest.NewRequest("POST", "/batch", strings.NewReader(string(b))))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var results []batchResult
+	json.Unmarshal(rr.Body.Bytes(), &results)
+	queued, full := 0, 0
+	for _, r := range results {
+		switch {
+		case r.State == "queued" && r.ID != "":
+			queued++
+		case r.State == "failed" && r.Error == "queue_full":
+			full++
+		default:
+			t.Errorf("unexpected result: %+v", r)
+		}
+	}
+	if queued == 0 || full == 0 {
+		t.Errorf("expected both queued and queue_full items, got %+v", results)
+	}
+}
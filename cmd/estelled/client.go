@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// clientIDSources is the list of sources to identify a client, tried in order.
+// Each element is one of "header", "key", "uid" and "addr".
+var clientIDSources []string
+
+// peerCred holds the credentials of the peer process of a unix domain socket.
+type peerCred struct {
+	UID    uint32
+	GID    uint32
+	PID    int32
+	Groups []uint32 // Supplementary groups. Available only on Linux.
+}
+
+type ctxKeyPeerCred struct{}
+
+// connContext is used as http.Server.ConnContext to remember the peer credentials of each connection.
+func connContext(ctx context.Context, c net.Conn) context.Context {
+	if uc, ok := c.(*net.UnixConn); ok {
+		if cred, err := getPeerCred(uc); err == nil {
+			return context.WithValue(ctx, ctxKeyPeerCred{}, cred)
+		}
+	}
+	return ctx
+}
+
+// peerCredFromContext returns the peer credentials stored by connContext.
+func peerCredFromContext(ctx context.Context) (peerCred, bool) {
+	cred, ok := ctx.Value(ctxKeyPeerCred{}).(peerCred)
+	return cred, ok
+}
+
+// clientID returns the identity of the client which sent req, used for fair queuing.
+// It returns the empty string if none of clientIDSources is available.
+func clientID(req *http.Request) string {
+	for _, src := range clientIDSources {
+		switch src {
+		case "header":
+			if v := req.Header.Get("X-Estelle-Client"); v != "" {
+				return "header:" + v
+			}
+		case "key":
+			if k := requestKey(req); k != "" {
+				// Never expose the key itself
+				sum := sha256.Sum256([]byte(k))
+				return "key:" + hex.EncodeToString(sum[:8])
+			}
+		case "uid":
+			if cred, ok := peerCredFromContext(req.Context()); ok {
+				return fmt.Sprintf("uid:%d", cred.UID)
+			}
+		case "addr":
+			if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+				return "addr:" + host
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestClientID(t *testing.T) {
+	defer func(orig []string) { clientIDSources = orig }(clientIDSources)
+
+	cases := []struct {
+		name    string
+		sources []string
+		header  string
+		key     string
+		want    string
+	}{
+		{"No sources", nil, "app1", "", ""},
+		{"Header", []string{"header", "addr"}, "app1", "", "header:app1"},
+		{"Header missing falls back to addr", []string{"header", "addr"}, "", "", "addr:192.0.2.1"},
+		{"Key is hashed", []string{"key"}, "", "secret", "key:2bb80d537b1da3e3"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			clientIDSources = tc.sources
+			req := httptest.NewRequest("GET", "/get?key="+tc.key, nil)
+			if tc.header != "" {
+				req.Header.Set("X-Estelle-Client", tc.header)
+			}
+			if got := clientID(req); got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestClientIDPeerUID(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("SO_PEERCRED is supported only on Linux")
+	}
+	defer func(orig []string) { clientIDSources = orig }(clientIDSources)
+	clientIDSources = []string{"uid", "addr"}
+
+	sock := filepath.Join(t.TempDir(), "estelled.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, clientID(r))
+		}),
+		ConnContext: connContext,
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+		},
+	}}
+	resp, err := client.Get("http://estelled/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if want := fmt.Sprintf("uid:%d", os.Getuid()); string(body) != want {
+		t.Errorf("expected %q, got %q", want, body)
+	}
+}
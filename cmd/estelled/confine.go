@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// symlinkPolicy decides how symbolic links under an allowed directory are treated.
+type symlinkPolicy int
+
+const (
+	// symlinksWithin follows symbolic links only if they point into one of the allowed directories.
+	symlinksWithin symlinkPolicy = iota
+	// symlinksNever rejects sources with a symbolic link under the allowed directory.
+	symlinksNever
+)
+
+// accessPolicy decides who can get thumbnails of the sources under an allowed directory.
+type accessPolicy int
+
+const (
+	// accessAny allows anyone who can reach the daemon.
+	accessAny accessPolicy = iota
+	// accessPeer allows only the peer process of a unix domain socket which could read the source by itself.
+	accessPeer
+)
+
+// allowedDir is a directory from which sources are allowed to be read.
+type allowedDir struct {
+	path   string // Absolute path as configured, with a trailing separator
+	real   string // path with symbolic links resolved, with a trailing separator
+	policy symlinkPolicy
+	access accessPolicy
+}
+
+// parseAllowedDir parses an entry of ESTELLE_ALLOWED_DIRS, which is DIR or DIR=POLICY[,POLICY].
+// POLICY is either of the symlink policies (within, never) or the access policies (any, peer).
+func parseAllowedDir(entry string) (allowedDir, error) {
+	dir, policies, _ := strings.Cut(entry, "=")
+	var policy symlinkPolicy
+	var access accessPolicy
+	for _, p := range strings.Split(policies, ",") {
+		switch strings.ToLower(strings.TrimSpace(p)) {
+		case "":
+			continue // e.g. trailing comma
+		case "within":
+			policy = symlinksWithin
+		case "never":
+			policy = symlinksNever
+		case "any":
+			access = accessAny
+		case "peer":
+			access = accessPeer
+		default:
+			return allowedDir{}, fmt.Errorf("invalid policy %q for %s (must be within, never, any or peer)", p, dir)
+		}
+	}
+	d, err := newAllowedDir(dir, policy)
+	d.access = access
+	return d, err
+}
+
+func newAllowedDir(dir string, policy symlinkPolicy) (allowedDir, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return allowedDir{}, err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return allowedDir{}, err
+	}
+	sep := string(os.PathSeparator)
+	return allowedDir{path: abs + sep, real: real + sep, policy: policy}, nil
+}
+
+// confine checks that source is in one of allowedDirs even after resolving symbolic links,
+// according to the policies of the directory, and returns the resolved path of source.
+// If the access policy is peer, the peer credentials are taken from ctx (see connContext).
+// Denials are logged, since they may indicate an attack.
+func confine(ctx context.Context, source string) (string, error) {
+	return resolveSource(ctx, source, true)
+}
+
+// canAccess reports whether the caller of ctx may access source, in the same way as confine
+// but without logging denials. It is used to filter notifications about sources.
+func canAccess(ctx context.Context, source string) bool {
+	_, err := resolveSource(ctx, source, false)
+	return err == nil
+}
+
+// inAllowedDirs reports whether path is one of allowedDirs or under them, without resolving symbolic links.
+func inAllowedDirs(path string) bool {
+	path = filepath.Clean(path) + string(os.PathSeparator)
+	for _, d := range allowedDirs {
+		if strings.HasPrefix(path, d.path) || strings.HasPrefix(path, d.real) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPeerDirs reports whether any of allowedDirs has the peer access policy.
+func hasPeerDirs() bool {
+	for _, d := range allowedDirs {
+		if d.access == accessPeer {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSource implements confine. Denials are logged if logDenial is true.
+func resolveSource(ctx context.Context, source string, logDenial bool) (string, error) {
+	deny := func(args ...any) {
+		if logDenial {
+			slog.Warn("Access denied", args...)
+		}
+	}
+	var dir *allowedDir
+	var rel string
+	for i := range allowedDirs {
+		d := &allowedDirs[i]
+		if r, ok := strings.CutPrefix(source, d.path); ok {
+			dir, rel = d, r
+			break
+		}
+		if r, ok := strings.CutPrefix(source, d.real); ok {
+			dir, rel = d, r
+			break
+		}
+	}
+	if dir == nil {
+		deny(sourceAttr("source", source), "reason", "not in allowed directories")
+		return "", HTTPError{code: http.StatusForbidden, msg: "Access denied: not in allowed directories"}
+	}
+
+	real, err := filepath.EvalSymlinks(source)
+	if err != nil {
+		return "", err
+	}
+	target := dir // The allowed directory which real is in
+	switch dir.policy {
+	case symlinksNever:
+		if real != dir.real+rel {
+			deny(sourceAttr("source", source), sourceAttr("resolved", real), "dir", dir.path, "reason", "symbolic link is not allowed")
+			return "", HTTPError{code: http.StatusForbidden, msg: "Access denied: symbolic links are not allowed"}
+		}
+	case symlinksWithin:
+		target = nil
+		for i := range allowedDirs {
+			if strings.HasPrefix(real, allowedDirs[i].real) {
+				target = &allowedDirs[i]
+				break
+			}
+		}
+		if target == nil {
+			deny(sourceAttr("source", source), sourceAttr("resolved", real), "dir", dir.path, "reason", "symbolic link points outside of allowed directories")
+			return "", HTTPError{code: http.StatusForbidden, msg: "Access denied: symbolic link points outside of allowed directories"}
+		}
+	}
+
+	if dir.access == accessPeer || target.access == accessPeer {
+		cred, ok := peerCredFromContext(ctx)
+		if !ok {
+			deny(sourceAttr("source", source), "dir", dir.path, "reason", "peer credentials are not available")
+			return "", HTTPError{code: http.StatusForbidden, msg: "Access denied: only available via unix domain socket"}
+		}
+		if err := peerCanRead(cred, real); err != nil {
+			deny(sourceAttr("source", source), sourceAttr("resolved", real), "uid", cred.UID, "gid", cred.GID, "pid", cred.PID, errorAttr("reason", err))
+			return "", HTTPError{code: http.StatusForbidden, msg: "Access denied: permission denied for the caller"}
+		}
+	}
+	return real, nil
+}
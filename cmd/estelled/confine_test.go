@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// allowDirs sets allowedDirs to dirs with the default symlink policy.
+func allowDirs(t *testing.T, dirs ...string) {
+	t.Helper()
+	allowedDirs = nil
+	for _, dir := range dirs {
+		d, err := newAllowedDir(dir, symlinksWithin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowedDirs = append(allowedDirs, d)
+	}
+}
+
+// setupEstelle sets up estelle with GoGenerator, which is stopped when the test finishes, and
+// a source image of 200x100 pixels in a new allowed directory. It returns the directory and the source.
+func setupEstelle(t *testing.T) (dir, src string) {
+	t.Helper()
+	dir = t.TempDir()
+	src = filepath.Join(dir, "200x100.png")
+	writePNG(t, src, 200, 100)
+	estl, err := New(filepath.Join(dir, "cache"), WithGenerator(GoGenerator{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { estl.Shutdown(context.Background()) })
+	estelle = estl
+	allowDirs(t, dir)
+	return dir, src
+}
+
+// writePNG writes a gray PNG image of width x height pixels to path.
+func writePNG(t *testing.T, path string, width, height int) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewGray(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConfine(t *testing.T) {
+	root := t.TempDir()
+	within, never, outside := filepath.Join(root, "within"), filepath.Join(root, "never"), filepath.Join(root, "outside")
+	for _, dir := range []string{within, never, outside, filepath.Join(within, "sub")} {
+		os.MkdirAll(dir, 0755)
+	}
+	for _, file := range []string{filepath.Join(within, "sub", "a.jpg"), filepath.Join(never, "b.jpg"), filepath.Join(outside, "secret.jpg")} {
+		os.WriteFile(file, []byte("image"), 0644)
+	}
+	links := map[string]string{
+		filepath.Join(within, "escape.jpg"):   filepath.Join(outside, "secret.jpg"),
+		filepath.Join(within, "escape"):       outside,
+		filepath.Join(within, "to-never.jpg"): filepath.Join(never, "b.jpg"),
+		filepath.Join(within, "alias"):        filepath.Join(within, "sub"),
+		filepath.Join(never, "link.jpg"):      filepath.Join(never, "b.jpg"),
+		filepath.Join(never, "to-within.jpg"): filepath.Join(within, "sub", "a.jpg"),
+	}
+	for link, target := range links {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skip("symlink is not supported:", err)
+		}
+	}
+	// The allowed directory itself may be reached through a symbolic link.
+	if err := os.Symlink(within, filepath.Join(root, "within-alias")); err != nil {
+		t.Fatal(err)
+	}
+
+	allowedDirs = nil
+	for _, entry := range []string{filepath.Join(root, "within-alias"), never + "=never"} {
+		d, err := parseAllowedDir(entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		allowedDirs = append(allowedDirs, d)
+	}
+	if _, err := parseAllowedDir(within + "=sometimes"); err == nil {
+		t.Error("expected error for invalid policy")
+	}
+	for _, entry := range []string{never + "=never,", never + "=never,,peer"} {
+		if d, err := parseAllowedDir(entry); err != nil || d.policy != symlinksNever {
+			t.Errorf("%s: empty items should be ignored, got (%v, %v)", entry, d.policy, err)
+		}
+	}
+
+	tests := []struct {
+		source string
+		want   string // Resolved path, or empty if denied
+	}{
+		{filepath.Join(within, "sub", "a.jpg"), filepath.Join(within, "sub", "a.jpg")},
+		{filepath.Join(root, "within-alias", "sub", "a.jpg"), filepath.Join(within, "sub", "a.jpg")},
+		{filepath.Join(within, "alias", "a.jpg"), filepath.Join(within, "sub", "a.jpg")},
+		{filepath.Join(within, "to-never.jpg"), filepath.Join(never, "b.jpg")},
+		{filepath.Join(within, "escape.jpg"), ""},
+		{filepath.Join(within, "escape", "secret.jpg"), ""},
+		{filepath.Join(outside, "secret.jpg"), ""},
+		{filepath.Join(never, "b.jpg"), filepath.Join(never, "b.jpg")},
+		{filepath.Join(never, "link.jpg"), ""},
+		{filepath.Join(never, "to-within.jpg"), ""},
+	}
+	for _, tt := range tests {
+		got, err := confine(context.Background(), tt.source)
+		if tt.want == "" {
+			var he HTTPError
+			if !errors.As(err, &he) || he.code != http.StatusForbidden {
+				t.Errorf("%s: expected 403, got (%q, %v)", tt.source, got, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s: expected %q, got (%q, %v)", tt.source, tt.want, got, err)
+		}
+	}
+
+	// Non-existent files are reported only in the allowed directories.
+	if _, err := confine(context.Background(), filepath.Join(within, "missing.jpg")); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	var he HTTPError
+	if _, err := confine(context.Background(), filepath.Join(outside, "missing.jpg")); !errors.As(err, &he) || he.code != http.StatusForbidden {
+		t.Errorf("expected 403, got %v", err)
+	}
+}
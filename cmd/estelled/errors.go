@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// errorBody is the JSON body of error responses.
+type errorBody struct {
+	Error   string `json:"error"`   // Machine-readable error code, e.g. "unsupported_format"
+	Message string `json:"message"` // Human-readable description
+}
+
+// writeError responds with the HTTP status and the JSON body corresponding to err.
+// Unexpected errors are logged and reported as 500 without details.
+func writeError(res http.ResponseWriter, req *http.Request, err error) {
+	status, code, msg := errorStatus(err)
+	var ge *GenerationError
+	if status == http.StatusInternalServerError {
+		slog.ErrorContext(req.Context(), "Request failed", "path", req.URL.Path, errorAttr("error", err))
+	} else if errors.As(err, &ge) {
+		slog.WarnContext(req.Context(), "Generation failed", "path", req.URL.Path, errorAttr("error", err))
+	}
+	var cfe *CachedFailureError
+	if errors.As(err, &cfe) {
+		res.Header().Set("Retry-After", strconv.Itoa(int(time.Until(cfe.Expires).Seconds())+1))
+	}
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(errorBody{Error: code, Message: msg})
+}
+
+// errorStatus maps err to the HTTP status code, the error code and the message.
+// The messages of generation failures are fixed for each kind, since the details, e.g. stderr
+// of the generator, may contain the paths of the server.
+func errorStatus(err error) (int, string, string) {
+	var he HTTPError
+	var tle *SourceTooLargeError
+	var cfe *CachedFailureError
+	var ge *GenerationError
+	switch {
+	case errors.As(err, &he):
+		return he.code, strings.ReplaceAll(strings.ToLower(http.StatusText(he.code)), " ", "_"), he.msg
+	case errors.Is(err, ErrEstelleQueueFull):
+		return http.StatusServiceUnavailable, "queue_full", "Task queue is full"
+	case errors.Is(err, ErrEstelleClosed):
+		return http.StatusServiceUnavailable, "shutting_down", "Server is shutting down"
+	case errors.Is(err, ErrEstelleClientQuota):
+		return http.StatusTooManyRequests, "client_quota_exceeded", "Too many queued tasks for this client"
+	case errors.As(err, &tle):
+		if tle.MaxFileSize > 0 {
+			return http.StatusRequestEntityTooLarge, "source_too_large", "Source file is too large"
+		}
+		return http.StatusUnprocessableEntity, "too_many_pixels", "Source image has too many pixels"
+	// Kinds of generation failures, including cached ones
+	case errors.Is(err, ErrUnsupportedFormat):
+		return http.StatusUnsupportedMediaType, "unsupported_format", "Unsupported image format"
+	case errors.Is(err, ErrCorruptSource):
+		return http.StatusUnprocessableEntity, "corrupt_source", "Source image is corrupt"
+	case errors.Is(err, ErrPermissionDenied):
+		return http.StatusForbidden, "permission_denied", "Permission denied"
+	case errors.Is(err, ErrGenerationTimeout):
+		return http.StatusGatewayTimeout, "timeout", "Thumbnail generation timed out"
+	case errors.Is(err, ErrDiskFull):
+		return http.StatusInsufficientStorage, "disk_full", "No space left on device"
+	case errors.Is(err, ErrGeneratorMissing):
+		return http.StatusInternalServerError, "generator_missing", "Thumbnail generator is missing"
+	case errors.As(err, &cfe):
+		return http.StatusUnprocessableEntity, "failed_previously", "Thumbnail generation failed previously"
+	case errors.As(err, &ge):
+		return http.StatusInternalServerError, "generation_failed", "Thumbnail generation failed"
+	}
+	return http.StatusInternalServerError, "internal_error", http.StatusText(http.StatusInternalServerError)
+}
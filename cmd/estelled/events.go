@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// eventsKeepAlive is the interval to send a comment line to keep idle connections alive.
+const eventsKeepAlive = 30 * time.Second
+
+// closeStreams is closed when the server is shutting down, to end /events streams
+// which would otherwise block http.Server.Shutdown.
+var closeStreams = make(chan struct{})
+
+// eventData is the JSON payload of an event sent by /events.
+type eventData struct {
+	ID      string `json:"id"`
+	Source  string `json:"source,omitempty"`
+	Path    string `json:"path"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleEvents streams thumbnail events as Server-Sent Events.
+// With ?prefix=DIR (repeatable), only the events of sources under DIR are sent.
+// Events of the sources which the client is not allowed to access (see confine) are not sent.
+func handleEvents(res http.ResponseWriter, req *http.Request) {
+	var prefixes []string
+	for _, p := range req.URL.Query()["prefix"] {
+		if !filepath.IsAbs(p) {
+			writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "prefix must be an absolute path"})
+			return
+		}
+		if !inAllowedDirs(p) {
+			writeError(res, req, HTTPError{code: http.StatusForbidden, msg: "Access denied: prefix is not in allowed directories"})
+			return
+		}
+		prefixes = append(prefixes, filepath.Clean(p))
+	}
+
+	// Subscribe before responding, so that clients don't miss events after they get the response.
+	events := estelle.Subscribe(req.Context(), 256)
+	rc := http.NewResponseController(res)
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return // Streaming is not supported
+	}
+
+	ticker := time.NewTicker(eventsKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return // Estelle is shut down, or this client is too slow and has lost events
+			}
+			if !visible(req.Context(), ev, prefixes) {
+				continue
+			}
+			b, _ := json.Marshal(newEventData(ev))
+			if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, b); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-closeStreams:
+			return
+		case <-req.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+func newEventData(ev Event) eventData {
+	d := eventData{ID: ev.ID, Source: ev.Source, Path: ev.Path}
+	switch ev.Type {
+	case EventGenerated:
+		d.Width, d.Height, d.Bytes = ev.Metadata.Width, ev.Metadata.Height, ev.Metadata.Bytes
+	case EventFailed:
+		_, d.Error, d.Message = errorStatus(ev.Err)
+	}
+	return d
+}
+
+// visible reports whether ev may be sent to the client of ctx, which subscribes with prefixes.
+// The source of an evicted thumbnail may be unknown, and then the event is sent only to
+// the clients which may see all the events.
+func visible(ctx context.Context, ev Event, prefixes []string) bool {
+	if ev.Source == "" {
+		return len(prefixes) == 0 && !hasPeerDirs()
+	}
+	return matchPrefix(ev.Source, prefixes) && canAccess(ctx, ev.Source)
+}
+
+// matchPrefix reports whether source is under any of prefixes. Empty prefixes match everything.
+func matchPrefix(source string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, p := range prefixes {
+		if source == p || strings.HasPrefix(source, strings.TrimSuffix(p, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestHandleEvents(t *testing.T) {
+	tempCache := t.TempDir()
+	var srcs []string
+	for _, dir := range []string{"a", "b"} {
+		os.MkdirAll(filepath.Join(tempCache, dir), 0755)
+		src := filepath.Join(tempCache, dir, "image.png")
+		writePNG(t, src, 200, 100)
+		srcs = append(srcs, src)
+	}
+
+	var errInit error
+	estelle, errInit = New(filepath.Join(tempCache, "cache"), WithGenerator(GoGenerator{}))
+	if errInit != nil {
+		t.Fatal(errInit)
+	}
+	defer estelle.Shutdown(context.Background())
+	allowDirs(t, tempCache)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", handleEvents)
+	mux.HandleFunc("GET /get", handleGet)
+	ts := httptest.NewServer(withLogger(mux))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/events?prefix=" + filepath.Join(tempCache, "b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected Content-Type: %s", ct)
+	}
+
+	// Only the event of b/image.png should be delivered.
+	for _, src := range srcs {
+		r, err := http.Get(ts.URL + "/get?size=50x50&mode=shrink&format=png&source=" + src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Body.Close()
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	var event string
+	for sc.Scan() {
+		line := sc.Text()
+		if v, ok := strings.CutPrefix(line, "event: "); ok {
+			event = v
+		}
+		if v, ok := strings.CutPrefix(line, "data: "); ok {
+			var d eventData
+			if err := json.Unmarshal([]byte(v), &d); err != nil {
+				t.Fatal(err)
+			}
+			if event != "generated" || d.Source != srcs[1] || d.Width != 50 || d.Height != 25 {
+				t.Errorf("unexpected event: %s %+v", event, d)
+			}
+			return
+		}
+	}
+	t.Fatal("no event received")
+}
+
+func TestHandleEventsPeer(t *testing.T) {
+	tempCache := t.TempDir()
+	var srcs []string
+	for _, dir := range []string{"private", "public"} {
+		os.MkdirAll(filepath.Join(tempCache, dir), 0755)
+		src := filepath.Join(tempCache, dir, "image.png")
+		f, err := os.Create(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		png.Encode(f, image.NewGray(image.Rect(0, 0, 200, 100)))
+		f.Close()
+		srcs = append(srcs, src)
+	}
+
+	var errInit error
+	estelle, errInit = New(filepath.Join(tempCache, "cache"), WithGenerator(GoGenerator{}))
+	if errInit != nil {
+		t.Fatal(errInit)
+	}
+	defer estelle.Shutdown(context.Background())
+	allowDirs(t, filepath.Join(tempCache, "private"), filepath.Join(tempCache, "public"))
+	allowedDirs[0].access = accessPeer
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /events", handleEvents)
+	mux.HandleFunc("GET /status/{id}", handleStatus)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	// Prefixes out of the allowed directories are rejected
+	r, err := http.Get(ts.URL + "/events?prefix=" + tempCache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for prefix out of allowed directories, got %d", r.StatusCode)
+	}
+
+	// Status of jobs is not available, since their sources cannot be checked
+	r, err = http.Get(ts.URL + "/status/0000000000000000000000000000000000000000-50x50-shrink.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Body.Close()
+	if r.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403 for /status, got %d", r.StatusCode)
+	}
+
+	resp, err := http.Get(ts.URL + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	// The event of the private source must not be delivered to the client via TCP.
+	for _, src := range srcs {
+		ti, err := estelle.NewThumbInfo(src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := estelle.Enqueue(ti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-res.Done()
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if v, ok := strings.CutPrefix(sc.Text(), "data: "); ok {
+			var d eventData
+			if err := json.Unmarshal([]byte(v), &d); err != nil {
+				t.Fatal(err)
+			}
+			if d.Source != srcs[1] {
+				t.Errorf("unexpected event: %+v", d)
+			}
+			return
+		}
+	}
+	t.Fatal("no event received")
+}
+
+func TestEventVisible(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "a.jpg")
+	os.WriteFile(src, []byte("image"), 0644)
+	allowDirs(t, dir)
+	ctx := context.Background()
+	known := Event{Type: EventEvicted, Source: src}
+	unknown := Event{Type: EventEvicted}
+
+	if !visible(ctx, known, nil) || !visible(ctx, known, []string{dir}) || visible(ctx, known, []string{"/x"}) {
+		t.Error("evicted event with the source should be filtered by prefix")
+	}
+	if !visible(ctx, unknown, nil) {
+		t.Error("evicted event without the source should be sent to clients without prefix")
+	}
+	if visible(ctx, unknown, []string{dir}) {
+		t.Error("evicted event without the source should not be sent to clients with prefix")
+	}
+	allowedDirs[0].access = accessPeer
+	if visible(ctx, unknown, nil) {
+		t.Error("evicted event without the source should not be sent under the peer policy")
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	tests := []struct {
+		source   string
+		prefixes []string
+		want     bool
+	}{
+		{"/a/b.jpg", nil, true},
+		{"", []string{"/x"}, false},
+		{"/a/b.jpg", []string{"/a"}, true},
+		{"/a/b.jpg", []string{"/x", "/a/"}, true},
+		{"/ab/c.jpg", []string{"/a"}, false},
+		{"/a/b.jpg", []string{"/x"}, false},
+	}
+	for _, tt := range tests {
+		if got := matchPrefix(tt.source, tt.prefixes); got != tt.want {
+			t.Errorf("matchPrefix(%q, %q) = %v, want %v", tt.source, tt.prefixes, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// handleFd generates the thumbnail in the same way as /get, but sends its file descriptor
+// instead of the path, so that the client can read it without access to the cache directory.
+// It is available only via unix domain socket. The response has no body; the descriptor,
+// opened read-only, is attached to the response header with SCM_RIGHTS.
+func handleFd(res http.ResponseWriter, req *http.Request) {
+	addr, _ := req.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if addr == nil || addr.Network() != "unix" {
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "/fd is only available via unix domain socket"})
+		return
+	}
+	ti, err := thumbInfoFromReq(req)
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+	f, meta, ok := openThumb(res, req, ti)
+	if !ok {
+		return
+	}
+	defer f.Close()
+
+	conn, _, err := http.NewResponseController(res).Hijack()
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+	defer conn.Close()
+
+	// The header is written by ourselves, since http.ResponseWriter cannot carry ancillary data.
+	res.Header().Set("Content-Type", meta.Format.MimeType())
+	res.Header().Set("Content-Length", "0")
+	res.Header().Set("Connection", "close")
+	setDimensions(res, meta)
+	var buf bytes.Buffer
+	buf.WriteString("HTTP/1.1 200 OK\r\n")
+	res.Header().Write(&buf)
+	buf.WriteString("\r\n")
+	if err := sendFile(conn.(*net.UnixConn), buf.Bytes(), f); err != nil {
+		// The client receives nothing, so record the failure in the request log too.
+		setLogStatus(res, http.StatusInternalServerError)
+		slog.ErrorContext(req.Context(), "Failed to send file descriptor", "path", req.URL.Path, errorAttr("error", err))
+	}
+}
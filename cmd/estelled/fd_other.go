@@ -0,0 +1,14 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// sendFile is not supported on this platform.
+func sendFile(conn *net.UnixConn, msg []byte, f *os.File) error {
+	return errors.New("passing file descriptors is not supported on this platform")
+}
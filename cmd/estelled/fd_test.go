@@ -0,0 +1,77 @@
+//go:build unix
+
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+	"github.com/Maki-Daisuke/estelle/v2/client"
+)
+
+func TestHandleFd(t *testing.T) {
+	tempCache, src := setupEstelle(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /fd", handleFd)
+	sock := filepath.Join(t.TempDir(), "estelled.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: withLogger(withAuth(mux, "secret", nil)), ConnContext: connContext}
+	go server.Serve(l)
+	defer server.Close()
+
+	c := &client.Client{Socket: sock, Key: "secret"}
+	thumb, err := c.Open(context.Background(), src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer thumb.File.Close()
+	if thumb.ContentType != "image/png" || thumb.Width != 50 || thumb.Height != 25 {
+		t.Errorf("unexpected thumbnail: %+v", thumb)
+	}
+	img, err := png.Decode(thumb.File)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := img.Bounds().Size(); got != image.Pt(50, 25) {
+		t.Errorf("expected 50x25, got %v", got)
+	}
+	if _, err := thumb.File.Write([]byte("x")); err == nil {
+		t.Error("the descriptor should be read-only")
+	}
+
+	// Errors are reported as *client.Error
+	_, err = c.Open(context.Background(), filepath.Join(tempCache, "missing.png"), SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	var ce *client.Error
+	if !errors.As(err, &ce) || ce.StatusCode != http.StatusNotFound || ce.Code != "not_found" {
+		t.Errorf("expected not_found, got %v", err)
+	}
+	c.Key = "wrong"
+	_, err = c.Open(context.Background(), src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	if !errors.As(err, &ce) || ce.StatusCode != http.StatusForbidden {
+		t.Errorf("expected 403, got %v", err)
+	}
+
+	// Not available via TCP
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/fd?source=" + src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 via TCP, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"os"
+	"syscall"
+)
+
+// sendFile writes msg to conn with the file descriptor of f attached.
+func sendFile(conn *net.UnixConn, msg []byte, f *os.File) error {
+	raw, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var werr error
+	err = raw.Control(func(fd uintptr) {
+		_, _, werr = conn.WriteMsgUnix(msg, syscall.UnixRights(int(fd)), nil)
+	})
+	if err != nil {
+		return err
+	}
+	return werr
+}
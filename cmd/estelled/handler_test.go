@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
@@ -56,7 +57,7 @@ func TestCacheIntegration(t *testing.T) {
 	defer estelle.Shutdown(context.Background())
 
 	// Set allowedDirs global for testing
-	allowedDirs = []string{tempCache}
+	allowDirs(t, tempCache)
 
 	// Setup Router
 	router := http.NewServeMux()
@@ -225,7 +226,7 @@ func TestThumbnailErrors(t *testing.T) {
 	defer estelle.Shutdown(context.Background())
 
 	// Set allowedDirs global for testing
-	allowedDirs = []string{tempCache}
+	allowDirs(t, tempCache)
 
 	// Setup Router
 	router := http.NewServeMux()
@@ -264,13 +265,13 @@ func TestThumbnailErrors(t *testing.T) {
 			wantCode: http.StatusBadRequest,
 		},
 		{
-			name: "500 Internal Server Error (Invalid image file)",
+			name: "415 Unsupported Media Type (Invalid image file)",
 			beforeFunc: func() string {
 				f := filepath.Join(tempCache, "invalid.jpg")
 				os.WriteFile(f, []byte("not an image"), 0644)
 				return "source=" + f
 			},
-			wantCode: http.StatusInternalServerError,
+			wantCode: http.StatusUnsupportedMediaType,
 		},
 	}
 
@@ -292,3 +293,142 @@ func TestThumbnailErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestSourceTooLarge(t *testing.T) {
+	tempCache := t.TempDir()
+
+	// 100x100 image
+	tempSourceFile := filepath.Join(tempCache, "source.jpg")
+	f, err := os.Create(tempSourceFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 100, 100)), nil); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	allowDirs(t, tempCache)
+
+	tests := []struct {
+		name     string
+		opt      Option
+		wantCode int
+	}{
+		{"422 Unprocessable Entity (Too many pixels)", WithMaxPixels(100*100 - 1), http.StatusUnprocessableEntity},
+		{"413 Request Entity Too Large (Too large file)", WithMaxFileSize(10), http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var errInit error
+			estelle, errInit = New(filepath.Join(tempCache, "cache"), tt.opt)
+			if errInit != nil {
+				t.Fatal(errInit)
+			}
+			defer estelle.Shutdown(context.Background())
+
+			for _, h := range []http.HandlerFunc{handleGet, handleQueue} {
+				rr := httptest.NewRecorder()
+				h(rr, httptest.NewRequest("GET", "/?source="+tempSourceFile, nil))
+				if rr.Code != tt.wantCode {
+					t.Errorf("expected status %d, got %d", tt.wantCode, rr.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestNegativeCacheStatus(t *testing.T) {
+	tempCache := t.TempDir()
+	var errInit error
+	estelle, errInit = New(tempCache, WithNegativeCacheTTL(time.Hour))
+	if errInit != nil {
+		t.Fatal(errInit)
+	}
+	defer estelle.Shutdown(context.Background())
+	allowDirs(t, tempCache)
+
+	invalid := filepath.Join(tempCache, "invalid.jpg")
+	os.WriteFile(invalid, []byte("not an image"), 0644)
+
+	handler := withRecovery(http.HandlerFunc(handleGet))
+	for i, retry := range []bool{false, true} {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", "/get?format=jpg&source="+invalid, nil))
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("request #%d: expected status %d, got %d", i+1, http.StatusUnsupportedMediaType, rr.Code)
+		}
+		var body struct{ Error, Message string }
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("request #%d: invalid JSON body %q: %v", i+1, rr.Body.String(), err)
+		}
+		if body.Error != "unsupported_format" {
+			t.Errorf("request #%d: expected error code unsupported_format, got %q", i+1, body.Error)
+		}
+		if got := rr.Header().Get("Retry-After") != ""; got != retry {
+			t.Errorf("request #%d: Retry-After should be set only for cached failures: %q", i+1, rr.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestThumbDimensionHeaders(t *testing.T) {
+	tempCache := t.TempDir()
+	src := filepath.Join(tempCache, "200x100.jpg")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jpeg.Encode(f, image.NewRGBA(image.Rect(0, 0, 200, 100)), nil)
+	f.Close()
+
+	var errInit error
+	estelle, errInit = New(filepath.Join(tempCache, "cache"), WithGenerator(GoGenerator{}))
+	if errInit != nil {
+		t.Fatal(errInit)
+	}
+	defer estelle.Shutdown(context.Background())
+	allowDirs(t, tempCache)
+
+	// The first request generates the thumbnail, and the second one hits the cache.
+	for i := 1; i <= 2; i++ {
+		rr := httptest.NewRecorder()
+		handleGet(rr, httptest.NewRequest("GET", "/get?size=50x50&mode=shrink&format=png&source="+src, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request #%d: expected status 200, got %d: %s", i, rr.Code, rr.Body.String())
+		}
+		if w, h := rr.Header().Get("X-Thumb-Width"), rr.Header().Get("X-Thumb-Height"); w != "50" || h != "25" {
+			t.Errorf("request #%d: expected 50x25, got %sx%s", i, w, h)
+		}
+	}
+}
+
+func TestDefaultFormat(t *testing.T) {
+	defer func(orig Format) { defaultFormat = orig }(defaultFormat)
+	if f := defaultFormatFor(VipsGenerator{}); f != FMT_WEBP {
+		t.Errorf("expected webp for VipsGenerator, got %s", f)
+	}
+	defaultFormat = defaultFormatFor(GoGenerator{})
+	if defaultFormat != FMT_JPG {
+		t.Errorf("expected jpg for GoGenerator, got %s", defaultFormat)
+	}
+	if f := parseQueryFormat(nil); f != FMT_JPG {
+		t.Errorf("expected the default format, got %s", f)
+	}
+	if f := parseQueryFormat([]string{"png"}); f != FMT_PNG {
+		t.Errorf("expected png, got %s", f)
+	}
+}
+
+func TestClientQuota(t *testing.T) {
+	tests := []struct{ quota, queueSize, want int }{
+		{0, 1024, 256},
+		{0, 2, 1},
+		{0, 0, 0}, // Unbounded queue
+		{-1, 1024, 0},
+		{10, 1024, 10},
+	}
+	for _, tt := range tests {
+		if got := clientQuota(tt.quota, tt.queueSize); got != tt.want {
+			t.Errorf("clientQuota(%d, %d): expected %d, got %d", tt.quota, tt.queueSize, tt.want, got)
+		}
+	}
+}
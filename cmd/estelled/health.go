@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// withHealth serves /healthz and /readyz in addition to next. They are neither authenticated
+// nor logged as requests, so that supervisors can probe the daemon frequently.
+func withHealth(next http.Handler) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", next)
+	mux.HandleFunc("GET /healthz", handleHealthz)
+	mux.HandleFunc("GET /readyz", handleReadyz)
+	return mux
+}
+
+// handleHealthz reports that the process is alive.
+func handleHealthz(res http.ResponseWriter, req *http.Request) {
+	writeJSON(res, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// checkResult is the result of a check in the /readyz response.
+type checkResult struct {
+	Status string `json:"status"` // "ok" or "error"
+	Error  string `json:"error,omitempty"`
+}
+
+// readyResponse is the JSON body of /readyz.
+type readyResponse struct {
+	Status string                 `json:"status"` // "ok" or "unavailable"
+	Checks map[string]checkResult `json:"checks"`
+}
+
+// handleReadyz reports whether the daemon is able to generate thumbnails. It returns
+// 503 Service Unavailable if any of the checks fails.
+func handleReadyz(res http.ResponseWriter, req *http.Request) {
+	body := readyResponse{Status: "ok", Checks: map[string]checkResult{}}
+	for _, c := range estelle.CheckHealth() {
+		if c.Err != nil {
+			body.Status = "unavailable"
+			body.Checks[c.Name] = checkResult{Status: "error", Error: checkMessage(c)}
+			slog.WarnContext(req.Context(), "Readiness check failed", "check", c.Name, errorAttr("error", c.Err))
+		} else {
+			body.Checks[c.Name] = checkResult{Status: "ok"}
+		}
+	}
+	status := http.StatusOK
+	if body.Status != "ok" {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(res, status, body)
+}
+
+// checkMessage returns the message of the failed check c for /readyz. The error itself is only
+// logged, since /readyz is not authenticated and the error may contain paths in the server.
+func checkMessage(c HealthCheck) string {
+	switch c.Name {
+	case "generator":
+		return "Thumbnail generator is not available"
+	case "cache_dir":
+		return "Cache directory is not writable"
+	case "gc":
+		return "Initial scan of the cache directory is in progress"
+	case "queue":
+		if errors.Is(c.Err, ErrEstelleQueueFull) {
+			return "Task queue is full"
+		}
+		return "Shutting down"
+	}
+	return "Check failed"
+}
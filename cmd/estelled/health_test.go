@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestHealthEndpoints(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	var err error
+	estelle, err = New(cacheDir, WithGenerator(GoGenerator{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estelle.Shutdown(context.Background())
+
+	// Health endpoints are not authenticated
+	handler := withHealth(withAuth(http.NotFoundHandler(), "secret", nil))
+	get := func(target string) (int, readyResponse) {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+		var body readyResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		return rr.Code, body
+	}
+
+	if code, body := get("/healthz"); code != http.StatusOK || body.Status != "ok" {
+		t.Errorf("/healthz: unexpected response: %d %+v", code, body)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	code, body := get("/readyz")
+	for code != http.StatusOK && time.Now().Before(deadline) { // Wait for the initial scan
+		time.Sleep(10 * time.Millisecond)
+		code, body = get("/readyz")
+	}
+	if code != http.StatusOK || body.Status != "ok" || len(body.Checks) != 4 || body.Checks["cache_dir"].Status != "ok" {
+		t.Errorf("/readyz: unexpected response: %d %+v", code, body)
+	}
+
+	os.RemoveAll(cacheDir)
+	code, body = get("/readyz")
+	if c := body.Checks["cache_dir"]; code != http.StatusServiceUnavailable || body.Status != "unavailable" || c.Status != "error" || c.Error != "Cache directory is not writable" {
+		t.Errorf("/readyz: unexpected response: %d %+v", code, body)
+	}
+	if c := body.Checks["generator"]; c.Status != "ok" {
+		t.Errorf("generator: unexpected result: %+v", c)
+	}
+}
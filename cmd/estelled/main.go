@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
-	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -26,19 +26,37 @@ import (
 )
 
 var config struct {
-	Addr           string  `env:"ESTELLE_ADDR" envDefault:":1186" desc:"Address to listen on"`
-	AllowedDirs    string  `env:"ESTELLE_ALLOWED_DIRS" desc:"Comma separated list of allowed directories"`
-	CacheDir       string  `env:"ESTELLE_CACHE_DIR" desc:"Directory to store thumbnails"`
-	Limit          string  `env:"ESTELLE_CACHE_LIMIT" envDefault:"1GB" desc:"Cache size limit (e.g. 1GB, 500MB)"`
-	GCHighRatio    float64 `env:"ESTELLE_GC_HIGH_RATIO" envDefault:"0.90" desc:"GC high water mark ratio"`
-	GCLowRatio     float64 `env:"ESTELLE_GC_LOW_RATIO" envDefault:"0.75" desc:"GC low water mark ratio"`
-	WorkerPoolSize int     `env:"ESTELLE_WORKERS" desc:"Number of worker goroutines"`
-	TaskBufferSize int     `env:"ESTELLE_QUEUE_SIZE" envDefault:"1024" desc:"Task queue buffer size"`
-	Secret         string  `env:"ESTELLE_SECRET" desc:"Secret key for authentication"`
+	Addr            string        `env:"ESTELLE_ADDR" envDefault:":1186" desc:"Address to listen on"`
+	AdminAddr       string        `env:"ESTELLE_ADMIN_ADDR" desc:"Address to listen on for /metrics and the admin API (default: served on ESTELLE_ADDR)"`
+	AllowedDirs     string        `env:"ESTELLE_ALLOWED_DIRS" desc:"List of allowed directories separated by the path list separator, each optionally followed by =POLICY[,POLICY] where POLICY is within, never, any or peer"`
+	CacheDir        string        `env:"ESTELLE_CACHE_DIR" desc:"Directory to store thumbnails"`
+	Limit           string        `env:"ESTELLE_CACHE_LIMIT" envDefault:"1GB" desc:"Cache size limit (e.g. 1GB, 500MB)"`
+	GCHighRatio     float64       `env:"ESTELLE_GC_HIGH_RATIO" envDefault:"0.90" desc:"GC high water mark ratio"`
+	GCLowRatio      float64       `env:"ESTELLE_GC_LOW_RATIO" envDefault:"0.75" desc:"GC low water mark ratio"`
+	WorkerPoolSize  int           `env:"ESTELLE_WORKERS" desc:"Number of worker goroutines"`
+	TaskBufferSize  int           `env:"ESTELLE_QUEUE_SIZE" envDefault:"1024" desc:"Task queue buffer size"`
+	ClientQuota     int           `env:"ESTELLE_CLIENT_QUOTA" envDefault:"0" desc:"Max queued tasks per client while other clients have queued tasks (0 means a quarter of ESTELLE_QUEUE_SIZE, -1 means unlimited)"`
+	ClientID        string        `env:"ESTELLE_CLIENT_ID" envDefault:"uid,addr" desc:"Comma separated sources of client identity (header, key, uid, addr)"`
+	StarvationLimit int           `env:"ESTELLE_STARVATION_LIMIT" envDefault:"8" desc:"Max /get tasks run in a row while /queue tasks are waiting"`
+	MaxPixels       int64         `env:"ESTELLE_MAX_PIXELS" envDefault:"100000000" desc:"Max pixels (width * height) of source images (0 means unlimited)"`
+	MaxSourceSize   string        `env:"ESTELLE_MAX_SOURCE_SIZE" envDefault:"0" desc:"Max size of source files, e.g. 50MB (0 means unlimited)"`
+	Secret          string        `env:"ESTELLE_SECRET" secret:"true" desc:"Secret key for authentication"`
+	SigningKeys     string        `env:"ESTELLE_SIGNING_KEYS" secret:"true" desc:"Comma separated keys to verify signed URLs (multiple keys are accepted for rotation)"`
+	AdminSecret     string        `env:"ESTELLE_ADMIN_SECRET" secret:"true" desc:"Secret key for the admin API (the admin API is disabled if empty)"`
+	Generator       string        `env:"ESTELLE_GENERATOR" envDefault:"auto" desc:"Thumbnail generator (auto, vips or go)"`
+	GenTimeout      time.Duration `env:"ESTELLE_GEN_TIMEOUT" envDefault:"60s" desc:"Timeout of a single thumbnail generation (0 means no timeout)"`
+	NegativeTTL     time.Duration `env:"ESTELLE_NEGATIVE_CACHE_TTL" envDefault:"10m" desc:"How long to remember failed generations (0 disables)"`
+	CancelAbandoned bool          `env:"ESTELLE_CANCEL_ABANDONED" envDefault:"false" desc:"Kill running generation when all /get clients have disconnected"`
+	Derive          bool          `env:"ESTELLE_DERIVE" envDefault:"false" desc:"Generate thumbnails from larger cached thumbnails of the same source"`
+	LogSource       string        `env:"ESTELLE_LOG_SOURCE" envDefault:"full" desc:"How to log source paths (full, hash or omit)"`
+	CacheControl    string        `env:"ESTELLE_CACHE_CONTROL" envDefault:"private, max-age=3600" desc:"Cache-Control header of /thumb responses"`
 }
 
 var estelle *Estelle
-var allowedDirs []string
+var allowedDirs []allowedDir
+
+// defaultFormat is the output format used when the format parameter is omitted or invalid.
+var defaultFormat = FMT_WEBP
 
 func main() {
 	flag.Usage = usage
@@ -63,14 +81,42 @@ func main() {
 		flag.Usage()
 		os.Exit(1)
 	}
-	allowedDirs = filepath.SplitList(config.AllowedDirs)
-	for i, dir := range allowedDirs {
-		abs, err := filepath.Abs(dir)
+	allowedDirs = nil
+	for _, entry := range filepath.SplitList(config.AllowedDirs) {
+		dir, err := parseAllowedDir(entry)
 		if err != nil {
-			slog.Error("Failed to get absolute path", "dir", dir, "error", err)
+			slog.Error("Invalid allowed directory", "entry", entry, "error", err)
+			os.Exit(1)
+		}
+		allowedDirs = append(allowedDirs, dir)
+	}
+
+	clientIDSources = nil
+	for _, src := range strings.Split(config.ClientID, ",") {
+		src = strings.ToLower(strings.TrimSpace(src))
+		switch src {
+		case "":
+			continue
+		case "header", "key", "uid", "addr":
+			clientIDSources = append(clientIDSources, src)
+		default:
+			slog.Error("Invalid client identity source", "ESTELLE_CLIENT_ID", config.ClientID, "source", src)
 			os.Exit(1)
 		}
-		allowedDirs[i] = abs + string(os.PathSeparator)
+	}
+
+	switch logSourceMode = strings.ToLower(config.LogSource); logSourceMode {
+	case "full", "hash", "omit":
+	default:
+		slog.Error("Invalid log source mode", "ESTELLE_LOG_SOURCE", config.LogSource)
+		os.Exit(1)
+	}
+
+	var signingKeys [][]byte
+	for _, key := range strings.Split(config.SigningKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			signingKeys = append(signingKeys, []byte(key))
+		}
 	}
 
 	limitBytes, err := parseBytes(config.Limit)
@@ -79,6 +125,17 @@ func main() {
 		os.Exit(1)
 	}
 
+	maxSourceBytes, err := parseBytes(config.MaxSourceSize)
+	if err != nil {
+		slog.Error("Invalid size format", "ESTELLE_MAX_SOURCE_SIZE", config.MaxSourceSize, "error", err)
+		os.Exit(1)
+	}
+
+	if config.StarvationLimit < 1 {
+		slog.Error("Starvation limit must be positive", "ESTELLE_STARVATION_LIMIT", config.StarvationLimit)
+		os.Exit(1)
+	}
+
 	if config.WorkerPoolSize == 0 {
 		config.WorkerPoolSize = runtime.NumCPU() / 2
 		if config.WorkerPoolSize < 1 {
@@ -86,6 +143,19 @@ func main() {
 		}
 	}
 
+	var gen Generator
+	switch strings.ToLower(config.Generator) {
+	case "auto", "":
+		gen = DefaultGenerator()
+	case "vips":
+		gen = VipsGenerator{}
+	case "go":
+		gen = GoGenerator{}
+	default:
+		slog.Error("Invalid generator", "ESTELLE_GENERATOR", config.Generator)
+		os.Exit(1)
+	}
+
 	// Setup signal handler to properly shutdown the goroutine behind Estelle
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer stop()
@@ -95,6 +165,15 @@ func main() {
 		WithGCRatio(config.GCHighRatio, config.GCLowRatio),
 		WithWorkers(config.WorkerPoolSize),
 		WithBufferSize(config.TaskBufferSize),
+		WithClientQuota(clientQuota(config.ClientQuota, config.TaskBufferSize)),
+		WithStarvationLimit(config.StarvationLimit),
+		WithGenerator(gen),
+		WithCancelAbandoned(config.CancelAbandoned),
+		WithGenerationTimeout(config.GenTimeout),
+		WithMaxFileSize(maxSourceBytes),
+		WithMaxPixels(config.MaxPixels),
+		WithNegativeCacheTTL(config.NegativeTTL),
+		WithDerivation(config.Derive),
 		WithPanicHandler(func(v interface{}) {
 			slog.Error("Worker Panic", "panic", v, "stack", string(debug.Stack()))
 		}),
@@ -103,22 +182,38 @@ func main() {
 		slog.Error("Failed to initialize estelle", "error", err)
 		os.Exit(1)
 	}
+	defaultFormat = defaultFormatFor(gen)
+	slog.Info("using generator", "generator", fmt.Sprintf("%T", gen), "default_format", defaultFormat.String())
+	if config.AdminSecret == "" {
+		slog.Info("admin API is disabled because ESTELLE_ADMIN_SECRET is not set")
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /get", handleGet)
 	mux.HandleFunc("POST /get", handleGet)
 	mux.HandleFunc("GET /queue", handleQueue)
 	mux.HandleFunc("POST /queue", handleQueue)
+	mux.HandleFunc("GET /thumb", handleThumb)
+	mux.HandleFunc("GET /fd", handleFd)
+	mux.HandleFunc("GET /peek", handlePeek)
+	mux.HandleFunc("GET /status/{id}", handleStatus)
+	mux.HandleFunc("GET /events", handleEvents)
+	mux.HandleFunc("POST /batch", handleBatch)
+
+	// Admin endpoints are served on a separate listener if ESTELLE_ADMIN_ADDR is set.
+	adminMux := mux
+	if config.AdminAddr != "" {
+		adminMux = http.NewServeMux()
+	}
+	adminMux.HandleFunc("GET /metrics", handleMetrics)
 
-	handler := withRecovery(withLogger(withAuth(mux, config.Secret)))
-
-	network := "tcp"
-	addr := config.Addr
-	if strings.HasPrefix(addr, "unix://") {
-		network = "unix"
-		addr = strings.TrimPrefix(addr, "unix://")
+	handler := withAuth(mux, config.Secret, signingKeys)
+	if adminMux == mux {
+		handler = withAdminAPI(handler)
 	}
+	handler = withRecovery(withHealth(withLogger(handler)))
 
+	network, addr := splitAddr(config.Addr)
 	l, err := net.Listen(network, addr)
 	if err != nil {
 		slog.Error("Failed to listen", "addr", config.Addr, "error", err)
@@ -126,9 +221,33 @@ func main() {
 	}
 	defer l.Close()
 
+	var adminServer *http.Server
+	if adminMux != mux {
+		adminNetwork, adminAddr := splitAddr(config.AdminAddr)
+		al, err := net.Listen(adminNetwork, adminAddr)
+		if err != nil {
+			slog.Error("Failed to listen", "addr", config.AdminAddr, "error", err)
+			os.Exit(1)
+		}
+		defer al.Close()
+		adminServer = &http.Server{
+			Handler:     withRecovery(withHealth(withLogger(withAdminAPI(withAuth(adminMux, config.Secret, signingKeys))))),
+			ConnContext: connContext,
+		}
+		go func() {
+			slog.Info("admin listening", "network", adminNetwork, "addr", adminAddr)
+			if err := adminServer.Serve(al); err != nil && err != http.ErrServerClosed {
+				slog.Error("Admin server failed", "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
+
 	server := &http.Server{
-		Handler: handler,
+		Handler:     handler,
+		ConnContext: connContext,
 	}
+	server.RegisterOnShutdown(func() { close(closeStreams) })
 
 	go func() {
 		slog.Info("listening", "network", network, "addr", addr)
@@ -147,6 +266,11 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Error("Server forced to shutdown", "error", err)
 	}
+	if adminServer != nil {
+		if err := adminServer.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Admin server forced to shutdown", "error", err)
+		}
+	}
 	if err := estelle.Shutdown(shutdownCtx); err != nil {
 		slog.Error("Estelle shutdown failed", "error", err)
 	}
@@ -160,6 +284,15 @@ func main() {
 	}
 }
 
+// splitAddr splits addr into the network and the address for net.Listen.
+// addr is either a TCP address or unix:///path/to/socket.
+func splitAddr(addr string) (network, address string) {
+	if strings.HasPrefix(addr, "unix://") {
+		return "unix", strings.TrimPrefix(addr, "unix://")
+	}
+	return "tcp", addr
+}
+
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 	fmt.Fprintln(os.Stderr, "This application is configured via environment variables.")
@@ -212,74 +345,95 @@ func parseBytes(s string) (int64, error) {
 func handleGet(res http.ResponseWriter, req *http.Request) {
 	ti, err := thumbInfoFromReq(req)
 	if err != nil {
-		var he HTTPError
-		if errors.As(err, &he) {
-			http.Error(res, he.msg, he.code)
-			return
-		}
-		panic(err)
+		writeError(res, req, err)
+		return
+	}
+	meta, ok := waitThumb(res, req, ti)
+	if !ok {
+		return
 	}
+	writePath(res, ti, meta)
+}
 
-	taskRes, err := estelle.Enqueue(ti)
+// waitThumb enqueues ti with the interactive priority and waits for it.
+// If it fails, the error response is written and ok is false.
+func waitThumb(res http.ResponseWriter, req *http.Request, ti ThumbInfo) (meta Metadata, ok bool) {
+	// The task is dropped if the client disconnects before it starts.
+	taskRes, err := estelle.EnqueueContext(req.Context(), ti, WithPriority(PriorityInteractive), WithClient(clientID(req)))
 	if err != nil {
-		if err == ErrEstelleQueueFull {
-			http.Error(res, "Task queue is full", http.StatusServiceUnavailable)
-			return
+		if req.Context().Err() != nil {
+			return Metadata{}, false // Client has gone
 		}
-		panic(err)
+		writeError(res, req, err)
+		return Metadata{}, false
 	}
 
-	if taskRes != nil {
-		select {
-		case <-taskRes.Done():
-			if err := taskRes.Err(); err != nil {
-				panic(err)
-			}
-		case <-req.Context().Done():
-			return
+	select {
+	case <-taskRes.Done():
+		if err := taskRes.Err(); err != nil {
+			writeError(res, req, err)
+			return Metadata{}, false
 		}
+	case <-req.Context().Done():
+		return Metadata{}, false
 	}
-
-	res.WriteHeader(200)
-	res.Write([]byte(ti.Path()))
+	return taskRes.Metadata(), true
 }
 
 func handleQueue(res http.ResponseWriter, req *http.Request) {
 	ti, err := thumbInfoFromReq(req)
 	if err != nil {
-		var he HTTPError
-		if errors.As(err, &he) {
-			http.Error(res, he.msg, he.code)
-			return
-		}
-		panic(err)
+		writeError(res, req, err)
+		return
 	}
 
-	taskRes, err := estelle.Enqueue(ti)
+	taskRes, err := estelle.Enqueue(ti, WithPriority(PriorityBackground), WithClient(clientID(req)))
 	if err != nil {
-		if err == ErrEstelleQueueFull {
-			http.Error(res, "Task queue is full", http.StatusServiceUnavailable)
-			return
-		}
-		panic(err)
+		writeError(res, req, err)
+		return
 	}
 
+	// The thumbnail ID serves as the job ID, which can be passed to /status/{id}.
+	res.Header().Set("X-Job-Id", ti.String())
 	select {
 	case <-taskRes.Done():
 		if err := taskRes.Err(); err != nil {
-			panic(err)
+			writeError(res, req, err)
+			return
 		}
-		res.WriteHeader(200)
-		res.Write([]byte(ti.Path()))
+		writePath(res, ti, taskRes.Metadata())
 		return
 	default:
+		res.Header().Set("Location", "/status/"+ti.String())
 		res.WriteHeader(202) // Accepted
+		res.Write([]byte(ti.String()))
 		return
 	}
 }
 
+// writePath responds with the path to the thumbnail, reporting its dimensions in the headers
+// so that clients can lay out without decoding it.
+func writePath(res http.ResponseWriter, ti ThumbInfo, meta Metadata) {
+	setDimensions(res, meta)
+	res.WriteHeader(200)
+	res.Write([]byte(ti.Path()))
+}
+
+// setDimensions sets X-Thumb-Width and X-Thumb-Height headers, if the dimensions are known.
+func setDimensions(res http.ResponseWriter, meta Metadata) {
+	if meta.Width > 0 && meta.Height > 0 {
+		res.Header().Set("X-Thumb-Width", strconv.Itoa(meta.Width))
+		res.Header().Set("X-Thumb-Height", strconv.Itoa(meta.Height))
+	}
+}
+
 func thumbInfoFromReq(req *http.Request) (ThumbInfo, error) {
-	source := req.URL.Query().Get("source")
+	return thumbInfoFromQuery(req.Context(), req.URL.Query())
+}
+
+// thumbInfoFromQuery validates the parameters and creates ThumbInfo.
+func thumbInfoFromQuery(ctx context.Context, query url.Values) (ThumbInfo, error) {
+	source := query.Get("source")
 	if source == "" {
 		return ThumbInfo{}, HTTPError{code: http.StatusBadRequest, msg: "source is required"}
 	}
@@ -288,20 +442,18 @@ func thumbInfoFromReq(req *http.Request) (ThumbInfo, error) {
 		return ThumbInfo{}, HTTPError{code: http.StatusBadRequest, msg: "source must be an absolute path"}
 	}
 
-	allowed := false
-	for _, dir := range allowedDirs {
-		if strings.HasPrefix(source, dir) {
-			allowed = true
-			break
+	// Use the resolved path, so that the generator does not follow the symbolic links by itself.
+	source, err := confine(ctx, source)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ThumbInfo{}, HTTPError{code: http.StatusNotFound, msg: "Not found"}
 		}
-	}
-	if !allowed {
-		return ThumbInfo{}, HTTPError{code: http.StatusForbidden, msg: "Access denied: not in allowed directories"}
+		return ThumbInfo{}, err
 	}
 
-	size := parseQuerySize(req.URL.Query()["size"])
-	mode := parseQueryMode(req.URL.Query()["mode"])
-	format := parseQueryFormat(req.URL.Query()["format"])
+	size := parseQuerySize(query["size"])
+	mode := parseQueryMode(query["mode"])
+	format := parseQueryFormat(query["format"])
 	ti, err := estelle.NewThumbInfo(source, size, mode, format)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -339,6 +491,29 @@ func parseQueryFormat(query []string) Format {
 			return f
 		}
 	}
+	return defaultFormat
+}
+
+// clientQuota returns the quota per client for WithClientQuota from ESTELLE_CLIENT_QUOTA.
+// 0 means a fair share of the queue, so that a single client cannot crowd out the others by default,
+// and a negative value means unlimited.
+func clientQuota(quota, queueSize int) int {
+	switch {
+	case quota < 0:
+		return 0
+	case quota == 0 && queueSize > 0:
+		return max(queueSize/4, 1)
+	}
+	return quota
+}
+
+// defaultFormatFor returns the default output format for gen.
+// GoGenerator cannot write WebP, so JPEG is used instead, so that requests without format
+// succeed whichever generator is chosen.
+func defaultFormatFor(gen Generator) Format {
+	if _, ok := gen.(GoGenerator); ok {
+		return FMT_JPG
+	}
 	return FMT_WEBP
 }
 
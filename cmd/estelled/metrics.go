@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// handleMetrics exposes the statistics of Estelle in Prometheus text format.
+func handleMetrics(res http.ResponseWriter, req *http.Request) {
+	res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeMetrics(res, estelle.Stats())
+}
+
+// writeMetrics writes st in Prometheus text exposition format.
+func writeMetrics(out io.Writer, st Stats) error {
+	w := bufio.NewWriter(out)
+	metric := func(name, typ, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	metric("estelle_cache_hits_total", "counter", "Requests served from the cache.")
+	fmt.Fprintf(w, "estelle_cache_hits_total %d\n", st.Hits)
+	metric("estelle_cache_misses_total", "counter", "Requests which started a new generation.")
+	fmt.Fprintf(w, "estelle_cache_misses_total %d\n", st.Misses)
+	metric("estelle_coalesced_waits_total", "counter", "Requests which joined the pending generation of the same thumbnail.")
+	fmt.Fprintf(w, "estelle_coalesced_waits_total %d\n", st.CoalescedWaits)
+	metric("estelle_rejected_total", "counter", "Requests rejected before being queued.")
+	fmt.Fprintf(w, "estelle_rejected_total{reason=\"queue_full\"} %d\n", st.QueueFull)
+	fmt.Fprintf(w, "estelle_rejected_total{reason=\"client_quota_exceeded\"} %d\n", st.QuotaExceeded)
+	metric("estelle_generations_total", "counter", "Completed thumbnail generations.")
+	fmt.Fprintf(w, "estelle_generations_total{result=\"success\"} %d\n", st.Generated)
+	fmt.Fprintf(w, "estelle_generations_total{result=\"failure\"} %d\n", st.Failed)
+
+	metric("estelle_queue_length", "gauge", "Jobs waiting in the queue.")
+	fmt.Fprintf(w, "estelle_queue_length %d\n", st.Queued)
+	metric("estelle_running_jobs", "gauge", "Jobs being executed by the workers.")
+	fmt.Fprintf(w, "estelle_running_jobs %d\n", st.Running)
+
+	metric("estelle_cache_bytes", "gauge", "Total size of the cache directory.")
+	fmt.Fprintf(w, "estelle_cache_bytes %d\n", st.CacheBytes)
+	metric("estelle_cache_limit_bytes", "gauge", "Cache size limit.")
+	fmt.Fprintf(w, "estelle_cache_limit_bytes %d\n", st.CacheLimit)
+	metric("estelle_gc_runs_total", "counter", "Garbage collection cycles.")
+	fmt.Fprintf(w, "estelle_gc_runs_total %d\n", st.GCRuns)
+	metric("estelle_evictions_total", "counter", "Thumbnails removed by the garbage collector.")
+	fmt.Fprintf(w, "estelle_evictions_total %d\n", st.Evictions)
+	metric("estelle_evicted_bytes_total", "counter", "Total size of thumbnails removed by the garbage collector.")
+	fmt.Fprintf(w, "estelle_evicted_bytes_total %d\n", st.EvictedBytes)
+
+	keys := make([]FormatMode, 0, len(st.GenerationTime))
+	for k := range st.GenerationTime {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Format != keys[j].Format {
+			return keys[i].Format < keys[j].Format
+		}
+		return keys[i].Mode < keys[j].Mode
+	})
+	metric("estelle_generation_duration_seconds", "histogram", "Time taken by successful generations.")
+	for _, k := range keys {
+		h := st.GenerationTime[k]
+		labels := fmt.Sprintf("format=%q,mode=%q", k.Format.String(), k.Mode.String())
+		for i, bound := range h.Bounds {
+			le := strconv.FormatFloat(bound.Seconds(), 'g', -1, 64)
+			fmt.Fprintf(w, "estelle_generation_duration_seconds_bucket{%s,le=%q} %d\n", labels, le, h.Counts[i])
+		}
+		fmt.Fprintf(w, "estelle_generation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, h.Count)
+		fmt.Fprintf(w, "estelle_generation_duration_seconds_sum{%s} %s\n", labels, strconv.FormatFloat(h.Sum.Seconds(), 'g', -1, 64))
+		fmt.Fprintf(w, "estelle_generation_duration_seconds_count{%s} %d\n", labels, h.Count)
+	}
+	return w.Flush()
+}
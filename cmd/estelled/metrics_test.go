@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	st := Stats{
+		Hits:       3,
+		QueueFull:  2,
+		Generated:  1,
+		Queued:     4,
+		CacheBytes: 1024,
+		GenerationTime: map[FormatMode]Histogram{
+			{Format: FMT_JPG, Mode: ModeCrop}: {
+				Bounds: []time.Duration{100 * time.Millisecond, time.Second},
+				Counts: []uint64{1, 2},
+				Count:  3,
+				Sum:    2500 * time.Millisecond,
+			},
+		},
+	}
+	var b strings.Builder
+	if err := writeMetrics(&b, st); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, line := range []string{
+		"# TYPE estelle_cache_hits_total counter",
+		"estelle_cache_hits_total 3",
+		`estelle_rejected_total{reason="queue_full"} 2`,
+		`estelle_generations_total{result="success"} 1`,
+		"estelle_queue_length 4",
+		"estelle_cache_bytes 1024",
+		"# TYPE estelle_generation_duration_seconds histogram",
+		`estelle_generation_duration_seconds_bucket{format="jpg",mode="crop",le="0.1"} 1`,
+		`estelle_generation_duration_seconds_bucket{format="jpg",mode="crop",le="1"} 2`,
+		`estelle_generation_duration_seconds_bucket{format="jpg",mode="crop",le="+Inf"} 3`,
+		`estelle_generation_duration_seconds_sum{format="jpg",mode="crop"} 2.5`,
+		`estelle_generation_duration_seconds_count{format="jpg",mode="crop"} 3`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, out)
+		}
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+	var err error
+	estelle, err = New(filepath.Join(t.TempDir(), "cache"), WithGenerator(GoGenerator{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estelle.Shutdown(context.Background())
+
+	rr := httptest.NewRecorder()
+	handleMetrics(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type: %s", ct)
+	}
+	if !strings.Contains(rr.Body.String(), "estelle_cache_limit_bytes 1073741824\n") {
+		t.Errorf("unexpected body:\n%s", rr.Body.String())
+	}
+}
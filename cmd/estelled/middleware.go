@@ -5,17 +5,48 @@ import (
 	"log/slog"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
 )
 
-func withAuth(next http.Handler, s string) http.Handler {
-	if s == "" {
+// signablePaths are the endpoints which accept signed URLs instead of the secret key.
+// The signature covers the thumbnail parameters, so it is valid only for those which take them.
+// It also covers the path, so that a URL signed for an endpoint cannot be used for another.
+var signablePaths = map[string]bool{"/get": true, "/queue": true, "/thumb": true, "/fd": true, "/peek": true}
+
+// requestKey returns the secret key sent with r, taken from "Authorization: Bearer" header,
+// X-Estelle-Key header or "key" query parameter in this order.
+func requestKey(r *http.Request) string {
+	if scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "Bearer") {
+		return strings.TrimSpace(token)
+	}
+	if k := r.Header.Get("X-Estelle-Key"); k != "" {
+		return k
+	}
+	return r.URL.Query().Get("key")
+}
+
+// withAuth requires the secret key s (see requestKey), or a valid signature by one of
+// signingKeys on signablePaths. Authentication is disabled if neither is given.
+func withAuth(next http.Handler, s string, signingKeys [][]byte) http.Handler {
+	if s == "" && len(signingKeys) == 0 {
 		return next
 	}
 	secret := []byte(s)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Query().Get("key")
-		if subtle.ConstantTimeCompare([]byte(key), secret) != 1 {
+		query := r.URL.Query()
+		if len(signingKeys) > 0 && query.Has("sig") && signablePaths[r.URL.Path] {
+			if err := VerifyQuery(signingKeys, r.URL.Path, query, time.Now()); err != nil {
+				http.Error(w, "Forbidden: "+err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := requestKey(r)
+		if len(secret) == 0 || subtle.ConstantTimeCompare([]byte(key), secret) != 1 {
 			http.Error(w, "Forbidden", http.StatusForbidden)
 			return
 		}
@@ -43,7 +74,7 @@ func withLogger(next http.Handler) http.Handler {
 		next.ServeHTTP(rw, r)
 		slog.Info("request",
 			"method", r.Method,
-			"path", r.RequestURI,
+			"path", redactURI(r.URL),
 			"remote_addr", r.RemoteAddr,
 			"status", rw.status,
 			"duration", time.Since(start),
@@ -60,3 +91,24 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.status = code
 	rw.ResponseWriter.WriteHeader(code)
 }
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// setLogStatus overrides the status logged by withLogger. It is for handlers which hijack
+// the connection, since they write the status line by themselves without WriteHeader.
+func setLogStatus(w http.ResponseWriter, code int) {
+	for {
+		switch rw := w.(type) {
+		case *responseWriter:
+			rw.status = code
+			return
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return
+		}
+	}
+}
@@ -3,7 +3,11 @@ package main
 import (
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
 )
 
 func TestWithAuth(t *testing.T) {
@@ -52,7 +56,7 @@ func TestWithAuth(t *testing.T) {
 			})
 
 			// Create middleware with the secret
-			middleware := withAuth(handler, tc.secret)
+			middleware := withAuth(handler, tc.secret, nil)
 
 			req := httptest.NewRequest("GET", "/?key="+tc.queryKey, nil)
 			rr := httptest.NewRecorder()
@@ -65,3 +69,81 @@ func TestWithAuth(t *testing.T) {
 		})
 	}
 }
+
+func TestWithAuthSigned(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	keys := [][]byte{[]byte("old"), []byte("new")}
+	signed := SignQuery(keys[1], "/thumb", "/foo/bar.jpg", SizeFromUint(400, 300), ModeCrop, FMT_WEBP, time.Now().Add(time.Hour)).Encode()
+	signedGet := SignQuery(keys[1], "/get", "/foo/bar.jpg", SizeFromUint(400, 300), ModeCrop, FMT_WEBP, time.Now().Add(time.Hour)).Encode()
+	expired := SignQuery(keys[1], "/thumb", "/foo/bar.jpg", SizeFromUint(400, 300), ModeCrop, FMT_WEBP, time.Now().Add(-time.Hour)).Encode()
+
+	cases := []struct {
+		name           string
+		secret         string
+		target         string
+		expectedStatus int
+	}{
+		{"Signed", "", "/thumb?" + signed, http.StatusOK},
+		{"Signed, with secret set", "mysecret", "/get?" + signedGet, http.StatusOK},
+		{"Signed for another endpoint", "", "/get?" + signed, http.StatusForbidden},
+		{"Expired", "", "/thumb?" + expired, http.StatusForbidden},
+		{"Tampered", "", "/thumb?" + strings.Replace(signed, "400x300", "800x600", 1), http.StatusForbidden},
+		{"Not signable path", "mysecret", "/status/x?" + signed, http.StatusForbidden},
+		{"Unsigned without secret", "", "/thumb?source=/foo/bar.jpg", http.StatusForbidden},
+		{"Secret key still works", "mysecret", "/batch?key=mysecret", http.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			withAuth(handler, tc.secret, keys).ServeHTTP(rr, httptest.NewRequest("GET", tc.target, nil))
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+func TestWithAuthHeaders(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	cases := []struct {
+		name           string
+		header         string
+		value          string
+		expectedStatus int
+	}{
+		{"Bearer", "Authorization", "Bearer mysecret", http.StatusOK},
+		{"Bearer, case-insensitive scheme", "Authorization", "bearer mysecret", http.StatusOK},
+		{"Bearer, incorrect key", "Authorization", "Bearer wrong", http.StatusForbidden},
+		{"Other scheme", "Authorization", "Basic mysecret", http.StatusForbidden},
+		{"X-Estelle-Key", "X-Estelle-Key", "mysecret", http.StatusOK},
+		{"X-Estelle-Key, incorrect key", "X-Estelle-Key", "wrong", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(tc.header, tc.value)
+			rr := httptest.NewRecorder()
+			withAuth(handler, "mysecret", nil).ServeHTTP(rr, req)
+			if rr.Code != tc.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tc.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
+
+type wrappedWriter struct{ http.ResponseWriter }
+
+func (w wrappedWriter) Unwrap() http.ResponseWriter { return w.ResponseWriter }
+
+func TestSetLogStatus(t *testing.T) {
+	rw := &responseWriter{httptest.NewRecorder(), http.StatusOK}
+	setLogStatus(wrappedWriter{rw}, http.StatusInternalServerError)
+	if rw.status != http.StatusInternalServerError {
+		t.Errorf("expected status %d to be logged, got %d", http.StatusInternalServerError, rw.status)
+	}
+	setLogStatus(httptest.NewRecorder(), http.StatusInternalServerError) // Must not panic without the logger
+}
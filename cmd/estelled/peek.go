@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// peekResponse is the JSON body of /peek.
+type peekResponse struct {
+	Path    string `json:"path,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+	Pending bool   `json:"pending"` // True if the generation is queued or running
+}
+
+// handlePeek reports whether the thumbnail exists in the cache, without generating it.
+func handlePeek(res http.ResponseWriter, req *http.Request) {
+	ti, err := thumbInfoFromReq(req)
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	meta, pending, err := estelle.Lookup(ti)
+	res.Header().Set("X-Thumb-Pending", strconv.FormatBool(pending))
+	if errors.Is(err, ErrNotCached) {
+		writePeek(res, http.StatusNotFound, peekResponse{Error: "not_cached", Message: err.Error(), Pending: pending})
+		return
+	}
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+	setDimensions(res, meta)
+	writePeek(res, http.StatusOK, peekResponse{
+		Path:   ti.Path(),
+		Width:  meta.Width,
+		Height: meta.Height,
+		Bytes:  meta.Bytes,
+		Format: meta.Format.String(),
+	})
+}
+
+func writePeek(res http.ResponseWriter, status int, body peekResponse) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(body)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestHandlePeek(t *testing.T) {
+	_, src := setupEstelle(t)
+	query := "?size=50x50&mode=shrink&format=png&source=" + src
+
+	peek := func() (int, peekResponse) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		handlePeek(rr, httptest.NewRequest("GET", "/peek"+query, nil))
+		var body peekResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid JSON body %q: %v", rr.Body.String(), err)
+		}
+		return rr.Code, body
+	}
+
+	if code, body := peek(); code != http.StatusNotFound || body.Error != "not_cached" || body.Pending {
+		t.Errorf("expected 404 not_cached, got %d %+v", code, body)
+	}
+	// Peek must not trigger generation.
+	ti, _ := estelle.NewThumbInfo(src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	if ti.Exists() {
+		t.Fatal("peek generated the thumbnail")
+	}
+
+	rr := httptest.NewRecorder()
+	handleGet(rr, httptest.NewRequest("GET", "/get"+query, nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("failed to generate the thumbnail: %d", rr.Code)
+	}
+	code, body := peek()
+	if code != http.StatusOK || body.Path != ti.Path() || body.Width != 50 || body.Height != 25 || body.Format != "png" {
+		t.Errorf("expected 200 with metadata, got %d %+v", code, body)
+	}
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package main
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// getPeerCred retrieves the credentials of the peer process via SO_PEERCRED and SO_PEERGROUPS.
+// They are recorded by the kernel at connect time, so they are the real credentials of the peer
+// even if the process has exited or changed them since.
+func getPeerCred(c *net.UnixConn) (peerCred, error) {
+	raw, err := c.SyscallConn()
+	if err != nil {
+		return peerCred{}, err
+	}
+	var ucred *unix.Ucred
+	var groups []uint32
+	var credErr error
+	err = raw.Control(func(fd uintptr) {
+		ucred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+		if credErr == nil {
+			groups, credErr = getPeerGroups(int(fd))
+		}
+	})
+	if err != nil {
+		return peerCred{}, err
+	}
+	if credErr != nil {
+		return peerCred{}, credErr
+	}
+	return peerCred{UID: ucred.Uid, GID: ucred.Gid, PID: ucred.Pid, Groups: groups}, nil
+}
+
+// getPeerGroups retrieves the supplementary groups of the peer process via SO_PEERGROUPS.
+// On kernels older than 4.13, which lack it, no supplementary groups are returned, so that
+// the peer is never granted more than its real credentials allow.
+func getPeerGroups(fd int) ([]uint32, error) {
+	groups := make([]uint32, 16)
+	for {
+		size := uint32(len(groups) * 4)
+		var p unsafe.Pointer
+		if len(groups) > 0 {
+			p = unsafe.Pointer(&groups[0])
+		}
+		_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT, uintptr(fd), unix.SOL_SOCKET, unix.SO_PEERGROUPS,
+			uintptr(p), uintptr(unsafe.Pointer(&size)), 0)
+		switch errno {
+		case 0:
+			return groups[:size/4], nil
+		case syscall.ERANGE: // size is set to the required one
+			groups = make([]uint32, size/4)
+		case syscall.ENOPROTOOPT:
+			return nil, nil
+		default:
+			return nil, errno
+		}
+	}
+}
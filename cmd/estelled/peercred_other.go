@@ -0,0 +1,13 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// getPeerCred is not supported on non-Linux systems.
+func getPeerCred(c *net.UnixConn) (peerCred, error) {
+	return peerCred{}, errors.New("SO_PEERCRED is not supported on this platform")
+}
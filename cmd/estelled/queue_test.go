@@ -56,7 +56,7 @@ func TestQueueOverflow(t *testing.T) {
 	defer estelle.Shutdown(context.Background())
 
 	// Set allowedDirs global for testing
-	allowedDirs = []string{tempCache}
+	allowDirs(t, tempCache)
 
 	// Setup Router
 	router := http.NewServeMux()
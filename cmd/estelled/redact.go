@@ -0,0 +1,111 @@
+package main
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// logSourceMode is how source paths are logged: "full", "hash" or "omit".
+var logSourceMode = "full"
+
+// sensitiveParams are the query parameters never to be logged.
+var sensitiveParams = []string{"key", "sig"}
+
+// logSource returns source as it should be logged according to logSourceMode.
+// ok is false if it should be omitted.
+func logSource(source string) (string, bool) {
+	switch logSourceMode {
+	case "hash":
+		sum := sha256.Sum256([]byte(source))
+		return "sha256:" + hex.EncodeToString(sum[:8]), true
+	case "omit":
+		return "", false
+	}
+	return source, true
+}
+
+// sourceAttr returns the log attribute of source. It is empty, which slog ignores, if omitted.
+func sourceAttr(key, source string) slog.Attr {
+	if s, ok := logSource(source); ok {
+		return slog.String(key, s)
+	}
+	return slog.Attr{}
+}
+
+// redactURI returns the request URI of u to be logged, with the sensitive parameters
+// replaced and the source transformed according to logSourceMode.
+func redactURI(u *url.URL) string {
+	if u.RawQuery == "" {
+		return u.EscapedPath()
+	}
+	query := u.Query()
+	for _, name := range sensitiveParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+		}
+	}
+	if sources, ok := query["source"]; ok {
+		query.Del("source")
+		for _, source := range sources {
+			if s, ok := logSource(source); ok {
+				query.Add("source", s)
+			}
+		}
+	}
+	if len(query) == 0 {
+		return u.EscapedPath()
+	}
+	return u.EscapedPath() + "?" + query.Encode()
+}
+
+// errorAttr returns the log attribute of err, with the paths in it transformed according to
+// logSourceMode, since errors of the file system and the generator contain them verbatim.
+func errorAttr(key string, err error) slog.Attr {
+	msg := err.Error()
+	if logSourceMode == "full" {
+		return slog.String(key, msg)
+	}
+	paths := errorPaths(err)
+	// Replace longer paths first, in case a path contains another one.
+	slices.SortFunc(paths, func(a, b string) int { return cmp.Compare(len(b), len(a)) })
+	for _, p := range paths {
+		s, ok := logSource(p)
+		if !ok {
+			s = "(omitted)"
+		}
+		msg = strings.ReplaceAll(msg, p, s)
+	}
+	return slog.String(key, msg)
+}
+
+// errorPaths returns the paths found in the tree of err.
+func errorPaths(err error) []string {
+	var paths []string
+	var pe *fs.PathError
+	var le *os.LinkError
+	var ge *GenerationError
+	var cfe *CachedFailureError
+	switch {
+	case errors.As(err, &pe):
+		paths = append(paths, pe.Path)
+	case errors.As(err, &le):
+		paths = append(paths, le.Old, le.New)
+	}
+	if errors.As(err, &ge) {
+		paths = append(paths, ge.Source)
+	}
+	if errors.As(err, &cfe) {
+		paths = append(paths, cfe.Source)
+	}
+	return slices.DeleteFunc(paths, func(p string) bool { return p == "" })
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestRedactURI(t *testing.T) {
+	defer func(orig string) { logSourceMode = orig }(logSourceMode)
+	const uri = "/thumb?source=%2Ffoo%2Fbar.jpg&size=400x300&key=mysecret&sig=abc"
+	cases := []struct {
+		mode     string
+		expected string
+	}{
+		{"full", "/thumb?key=REDACTED&sig=REDACTED&size=400x300&source=%2Ffoo%2Fbar.jpg"},
+		{"hash", "/thumb?key=REDACTED&sig=REDACTED&size=400x300&source=sha256%3A635a0c4670d1a1b1"},
+		{"omit", "/thumb?key=REDACTED&sig=REDACTED&size=400x300"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.mode, func(t *testing.T) {
+			logSourceMode = tc.mode
+			u, _ := url.Parse(uri)
+			if got := redactURI(u); got != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+
+	logSourceMode = "omit"
+	u, _ := url.Parse("/thumb?source=%2Ffoo%2Fbar.jpg")
+	if got := redactURI(u); got != "/thumb" {
+		t.Errorf("expected %q, got %q", "/thumb", got)
+	}
+}
+
+func TestWithLoggerRedaction(t *testing.T) {
+	defer func(orig *slog.Logger) { slog.SetDefault(orig) }(slog.Default())
+	var buf bytes.Buffer
+	slog.SetDefault(slog.New(slog.NewTextHandler(&buf, nil)))
+
+	handler := withLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/get?source=/foo/bar.jpg&key=mysecret", nil))
+	if log := buf.String(); strings.Contains(log, "mysecret") || !strings.Contains(log, "key=REDACTED") {
+		t.Errorf("secret is not redacted: %s", log)
+	}
+}
+
+func TestErrorAttr(t *testing.T) {
+	defer func(orig string) { logSourceMode = orig }(logSourceMode)
+	const source = "/foo/bar.jpg"
+	errs := []error{
+		&os.PathError{Op: "open", Path: source, Err: syscall.EACCES},
+		fmt.Errorf("failed: %w", &GenerationError{Kind: ErrCorruptSource, Source: source, Stderr: "VipsJpeg: " + source + ": Premature end of JPEG file", Err: errors.New("exit status 1")}),
+	}
+	for _, err := range errs {
+		logSourceMode = "full"
+		if got := errorAttr("error", err).Value.String(); !strings.Contains(got, source) {
+			t.Errorf("expected the path as is, got %q", got)
+		}
+		logSourceMode = "hash"
+		if got := errorAttr("error", err).Value.String(); strings.Contains(got, source) || !strings.Contains(got, "sha256:635a0c4670d1a1b1") {
+			t.Errorf("expected the hashed path, got %q", got)
+		}
+		logSourceMode = "omit"
+		if got := errorAttr("error", err).Value.String(); strings.Contains(got, source) {
+			t.Errorf("expected the path to be omitted, got %q", got)
+		}
+	}
+}
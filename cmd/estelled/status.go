@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// maxStatusWait is the upper limit of ?wait of /status/{id}.
+const maxStatusWait = 60 * time.Second
+
+// statusResponse is the JSON body of /status/{id}.
+type statusResponse struct {
+	ID      string `json:"id"`
+	State   string `json:"state"`
+	Path    string `json:"path,omitempty"`
+	Width   int    `json:"width,omitempty"`
+	Height  int    `json:"height,omitempty"`
+	Bytes   int64  `json:"bytes,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// handleStatus reports the state of the job queued by /queue.
+// With ?wait=DURATION, it blocks until the job completes or the duration elapses.
+// It is not available if any allowed directory has the peer access policy, because the source
+// of a job, which is needed to check the access, is unknown once it has been completed.
+func handleStatus(res http.ResponseWriter, req *http.Request) {
+	if hasPeerDirs() {
+		writeError(res, req, HTTPError{code: http.StatusForbidden, msg: "Access denied: /status is not available with the peer access policy"})
+		return
+	}
+	id := req.PathValue("id")
+	var wait time.Duration
+	if s := req.URL.Query().Get("wait"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: "wait must be a non-negative duration, e.g. 10s"})
+			return
+		}
+		wait = min(d, maxStatusWait)
+	}
+
+	st, err := estelle.Status(id)
+	if err != nil {
+		writeError(res, req, HTTPError{code: http.StatusBadRequest, msg: err.Error()})
+		return
+	}
+	if st.Result != nil && wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-st.Result.Done():
+			if err := st.Result.Err(); err != nil {
+				writeStatus(res, http.StatusOK, failedStatus(id, err))
+				return
+			}
+			st, _ = estelle.Status(id)
+		case <-timer.C:
+		case <-req.Context().Done():
+			return
+		}
+	}
+
+	body := statusResponse{ID: id, State: st.State.String()}
+	switch st.State {
+	case JobUnknown:
+		writeStatus(res, http.StatusNotFound, body)
+		return
+	case JobDone:
+		setDimensions(res, st.Metadata)
+		body.Path = st.Info.Path()
+		body.Width, body.Height, body.Bytes = st.Metadata.Width, st.Metadata.Height, st.Metadata.Bytes
+	case JobFailed:
+		body = failedStatus(id, st.Err)
+	}
+	writeStatus(res, http.StatusOK, body)
+}
+
+// failedStatus returns statusResponse of the job failed with err.
+func failedStatus(id string, err error) statusResponse {
+	_, code, msg := errorStatus(err)
+	return statusResponse{ID: id, State: JobFailed.String(), Error: code, Message: msg}
+}
+
+func writeStatus(res http.ResponseWriter, status int, body statusResponse) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(body)
+}
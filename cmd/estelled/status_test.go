@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+func TestHandleStatus(t *testing.T) {
+	_, src := setupEstelle(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /queue", handleQueue)
+	mux.HandleFunc("GET /status/{id}", handleStatus)
+	get := func(url string) (*httptest.ResponseRecorder, statusResponse) {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, httptest.NewRequest("GET", url, nil))
+		var body statusResponse
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr, body
+	}
+
+	ti, _ := estelle.NewThumbInfo(src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	if rr, body := get("/status/" + ti.String()); rr.Code != http.StatusNotFound || body.State != "unknown" {
+		t.Errorf("expected 404 unknown, got %d %+v", rr.Code, body)
+	}
+	if rr, _ := get("/status/..%2F..%2Fetc%2Fpasswd"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid ID, got %d", rr.Code)
+	}
+
+	rr, _ := get("/queue?size=50x50&mode=shrink&format=png&source=" + src)
+	if rr.Code != http.StatusAccepted && rr.Code != http.StatusOK {
+		t.Fatalf("expected 202, got %d", rr.Code)
+	}
+	if id := rr.Header().Get("X-Job-Id"); id != ti.String() {
+		t.Fatalf("expected job ID %s, got %s", ti.String(), id)
+	}
+
+	rr, body := get("/status/" + ti.String() + "?wait=5s")
+	if rr.Code != http.StatusOK || body.State != "done" || body.Path != ti.Path() || body.Width != 50 || body.Height != 25 {
+		t.Errorf("expected 200 done, got %d %+v", rr.Code, body)
+	}
+	if rr, _ := get("/status/" + ti.String() + "?wait=forever"); rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid wait, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+
+	. "github.com/Maki-Daisuke/estelle/v2"
+)
+
+// handleThumb serves the content of the thumbnail, generating it in the same way as /get.
+func handleThumb(res http.ResponseWriter, req *http.Request) {
+	ti, err := thumbInfoFromReq(req)
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	// The thumbnail ID contains the fingerprint of the source and all the parameters,
+	// so the same ID always means the same content.
+	etag := `"` + ti.String() + `"`
+	// The client already has it. No need to (re)generate the thumbnail even if it was evicted.
+	if inm := req.Header.Get("If-None-Match"); inm != "" && etagMatch(inm, etag) {
+		setCacheHeaders(res, etag)
+		res.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	f, meta, ok := openThumb(res, req, ti)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		writeError(res, req, err)
+		return
+	}
+
+	// Set only on success, so that errors are not cached by browsers and proxies.
+	setCacheHeaders(res, etag)
+	res.Header().Set("Content-Type", meta.Format.MimeType())
+	setDimensions(res, meta)
+	// ServeContent handles If-Modified-Since, If-Range and Range, and copies *os.File
+	// with sendfile(2) where available.
+	http.ServeContent(res, req, "", st.ModTime(), f)
+}
+
+// setCacheHeaders sets ETag and Cache-Control headers of the thumbnail.
+func setCacheHeaders(res http.ResponseWriter, etag string) {
+	res.Header().Set("ETag", etag)
+	if config.CacheControl != "" {
+		res.Header().Set("Cache-Control", config.CacheControl)
+	}
+}
+
+// openThumb waits for the thumbnail in the same way as waitThumb and opens it.
+// If it fails, the error response has been written and ok is false.
+func openThumb(res http.ResponseWriter, req *http.Request, ti ThumbInfo) (f *os.File, meta Metadata, ok bool) {
+	if meta, ok = waitThumb(res, req, ti); !ok {
+		return nil, meta, false
+	}
+	f, err := os.Open(ti.Path())
+	if os.IsNotExist(err) {
+		// Evicted right after generation. Try once more.
+		if meta, ok = waitThumb(res, req, ti); !ok {
+			return nil, meta, false
+		}
+		f, err = os.Open(ti.Path())
+	}
+	if err != nil {
+		writeError(res, req, err)
+		return nil, meta, false
+	}
+	return f, meta, true
+}
+
+// etagMatch reports whether If-None-Match header value lists etag.
+// "*" is left to http.ServeContent, since it matches only if the thumbnail exists.
+func etagMatch(inm, etag string) bool {
+	for _, t := range strings.Split(inm, ",") {
+		t = strings.TrimSpace(t)
+		if strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
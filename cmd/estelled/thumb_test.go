@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHandleThumb(t *testing.T) {
+	tempCache, src := setupEstelle(t)
+	config.CacheControl = "private, max-age=60"
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /thumb", handleThumb)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	url := ts.URL + "/thumb?size=50x50&mode=shrink&format=png&source=" + src
+
+	get := func(header ...string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", url, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, body
+	}
+
+	resp, body := get()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "image/png" {
+		t.Errorf("expected image/png, got %s", ct)
+	}
+	if cc := resp.Header.Get("Cache-Control"); cc != "private, max-age=60" {
+		t.Errorf("unexpected Cache-Control: %s", cc)
+	}
+	img, err := png.Decode(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 50 || b.Dy() != 25 {
+		t.Errorf("expected 50x25, got %v", b)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag is missing")
+	}
+
+	resp, _ = get("If-None-Match", etag)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status 304, got %d", resp.StatusCode)
+	}
+	resp, _ = get("If-Modified-Since", resp.Header.Get("Date"))
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status 304 for If-Modified-Since, got %d", resp.StatusCode)
+	}
+
+	resp, part := get("Range", "bytes=0-7")
+	if resp.StatusCode != http.StatusPartialContent {
+		t.Errorf("expected status 206, got %d", resp.StatusCode)
+	}
+	if string(part) != string(body[:8]) {
+		t.Errorf("unexpected partial content: %q", part)
+	}
+
+	// 304 without generation, even if the thumbnail has been evicted.
+	os.RemoveAll(filepath.Join(tempCache, "cache"))
+	os.MkdirAll(filepath.Join(tempCache, "cache"), 0755)
+	resp, _ = get("If-None-Match", etag)
+	if resp.StatusCode != http.StatusNotModified {
+		t.Errorf("expected status 304 for evicted thumbnail, got %d", resp.StatusCode)
+	}
+
+	// Errors must not be cached.
+	broken := filepath.Join(tempCache, "broken.png")
+	os.WriteFile(broken, []byte("not an image"), 0644)
+	url = ts.URL + "/thumb?size=50x50&mode=shrink&format=png&source=" + broken
+	resp, _ = get()
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("expected an error for broken source")
+	}
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Cache-Control") != "" {
+		t.Errorf("error response must not have cache headers: %v", resp.Header)
+	}
+}
@@ -0,0 +1,69 @@
+package estelle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// findBase returns the smallest cached thumbnail from which ti can be derived by downscaling,
+// instead of decoding the source. The candidates are the thumbnails with the same fingerprint hash
+// in the same shard directory, that is, the other variants of the same version of the source.
+func (dir ThumbInfoFactory) findBase(ti ThumbInfo) (ThumbInfo, bool) {
+	entries, err := os.ReadDir(filepath.Dir(ti.path))
+	if err != nil {
+		return ThumbInfo{}, false
+	}
+	prefix := ti.hash() + "-"
+	var base ThumbInfo
+	found := false
+	for _, e := range entries {
+		if !strings.HasPrefix(e.Name(), prefix) || !e.Type().IsRegular() || e.Name() == ti.id {
+			continue
+		}
+		cand, err := dir.FromID(e.Name())
+		if err != nil || !derivable(ti, cand) {
+			continue
+		}
+		if !found || area(cand.size) < area(base.size) {
+			base, found = cand, true
+		}
+	}
+	return base, found
+}
+
+// derivable reports whether downscaling base with the mode of ti results in the same thumbnail
+// as generating ti from the source.
+func derivable(ti, base ThumbInfo) bool {
+	if base.size.Width < ti.size.Width || base.size.Height < ti.size.Height || base.mode != ti.mode {
+		return false
+	}
+	if ti.mode == ModeCrop {
+		// The crop region depends on the aspect ratio, so it must be the same.
+		return uint64(base.size.Width)*uint64(ti.size.Height) == uint64(base.size.Height)*uint64(ti.size.Width)
+	}
+	return true
+}
+
+func area(s Size) uint64 {
+	return uint64(s.Width) * uint64(s.Height)
+}
+
+// makeDerived generates the thumbnail of res from a larger cached variant of the same source,
+// if any. It returns false if there is no such variant, without doing anything.
+func (estl *Estelle) makeDerived(ctx context.Context, res *Result) bool {
+	base, ok := estl.dir.findBase(res.ti)
+	if !ok {
+		return false
+	}
+	estl.generate(ctx, []*Result{res}, func(ctx context.Context) []error {
+		err := res.ti.makeFrom(ctx, estl.gen, base.path)
+		if err != nil && ctx.Err() == nil {
+			// The base may have been evicted meanwhile, or the Generator may not be able to read it.
+			err = res.ti.make(ctx, estl.gen)
+		}
+		return []error{err}
+	})
+	return true
+}
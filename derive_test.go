@@ -0,0 +1,102 @@
+package estelle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDerivable(t *testing.T) {
+	dir := ThumbInfoFactory(t.TempDir())
+	const hash = "0123456789abcdef0123456789abcdef01234567"
+	ti := func(id string) ThumbInfo {
+		ti, err := dir.FromID(hash + "-" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ti
+	}
+	tests := []struct {
+		target, base string
+		want         bool
+	}{
+		{"85x85-crop.jpg", "400x400-crop.jpg", true},
+		{"85x85-crop.jpg", "400x400-crop.webp", true},
+		{"85x60-crop.jpg", "400x400-crop.jpg", false}, // Different aspect ratio
+		{"85x85-crop.jpg", "400x400-shrink.jpg", false},
+		{"500x500-crop.jpg", "400x400-crop.jpg", false}, // Upscaling
+		{"100x100-shrink.png", "400x300-shrink.jpg", true},
+		{"100x400-shrink.png", "400x300-shrink.jpg", false},
+		{"100x30-stretch.png", "400x300-stretch.jpg", true},
+	}
+	for _, tt := range tests {
+		if got := derivable(ti(tt.target), ti(tt.base)); got != tt.want {
+			t.Errorf("derivable(%s, %s): expected %v, got %v", tt.target, tt.base, tt.want, got)
+		}
+	}
+
+	// findBase chooses the smallest one
+	target := ti("85x85-crop.jpg")
+	os.MkdirAll(filepath.Dir(target.Path()), 0755)
+	for _, id := range []string{"400x400-crop.jpg", "200x200-crop.png", "100x100-shrink.jpg", "incomplete_" + hash + "-90x90-crop.jpg"} {
+		if id[0] != 'i' {
+			id = hash + "-" + id
+		}
+		os.WriteFile(filepath.Join(filepath.Dir(target.Path()), id), []byte("thumbnail"), 0644)
+	}
+	base, ok := dir.findBase(target)
+	if !ok || base.String() != hash+"-200x200-crop.png" {
+		t.Errorf("expected 200x200-crop.png, got (%s, %v)", base, ok)
+	}
+	if _, ok := dir.findBase(ti("300x300-crop.jpg")); !ok {
+		t.Error("expected 400x400-crop.jpg to be found")
+	}
+	if base, ok := dir.findBase(ti("500x500-crop.jpg")); ok {
+		t.Errorf("expected no base, got %s", base)
+	}
+}
+
+func TestDerivation(t *testing.T) {
+	for _, enable := range []bool{true, false} {
+		tmpDir := t.TempDir()
+		srcs := makeSources(t, tmpDir, "a.jpg")
+		var mu sync.Mutex
+		var inputs []string
+		gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+			mu.Lock()
+			inputs = append(inputs, source)
+			mu.Unlock()
+			return os.WriteFile(output, []byte("thumbnail"), 0644)
+		})
+		estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithDerivation(enable))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tis []ThumbInfo
+		for _, size := range []Size{SizeFromUint(400, 400), SizeFromUint(85, 85), SizeFromUint(85, 60)} {
+			ti, _ := estl.NewThumbInfo(srcs[0], size, ModeCrop, FMT_JPG)
+			res, err := estl.Enqueue(ti)
+			if err != nil {
+				t.Fatal(err)
+			}
+			<-res.Done()
+			if err := res.Err(); err != nil {
+				t.Fatal(err)
+			}
+			tis = append(tis, ti)
+		}
+		estl.Shutdown(context.Background())
+
+		want := []string{srcs[0], srcs[0], srcs[0]}
+		if enable {
+			want[1] = tis[0].Path()
+		}
+		for i := range want {
+			if inputs[i] != want[i] {
+				t.Errorf("derivation=%v: #%d: expected to be generated from %s, got %s", enable, i, want[i], inputs[i])
+			}
+		}
+	}
+}
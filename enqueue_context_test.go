@@ -0,0 +1,257 @@
+package estelle
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// blockingGenerator blocks until unblock is closed or ctx is done.
+type blockingGenerator struct {
+	started   chan string
+	unblock   chan struct{}
+	cancelled chan error
+}
+
+func newBlockingGenerator() *blockingGenerator {
+	return &blockingGenerator{started: make(chan string, 16), unblock: make(chan struct{}), cancelled: make(chan error, 16)}
+}
+
+func (g *blockingGenerator) Generate(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+	g.started <- source
+	select {
+	case <-g.unblock:
+		return os.WriteFile(output, []byte("thumbnail"), 0644)
+	case <-ctx.Done():
+		g.cancelled <- context.Cause(ctx)
+		return ctx.Err()
+	}
+}
+
+func makeSources(t *testing.T, dir string, names ...string) []string {
+	t.Helper()
+	paths := make([]string, len(names))
+	for i, name := range names {
+		paths[i] = filepath.Join(dir, name)
+		if err := os.WriteFile(paths[i], []byte("dummy "+name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return paths
+}
+
+// waiters returns the number of the waiters of res, which is updated by context.AfterFunc asynchronously.
+func waiters(res *Result) int {
+	res.mu.Lock()
+	defer res.mu.Unlock()
+	return res.waiters
+}
+
+func TestEnqueueContextAbandonQueued(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithWorkers(1), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg")
+	tiA, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	tiB, _ := estl.NewThumbInfo(srcs[1], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	// Occupy the only worker.
+	resA, err := estl.Enqueue(tiA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	resB, err := estl.EnqueueContext(ctx, tiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case <-resB.Done():
+	case <-time.After(time.Second):
+		t.Fatal("abandoned task was not dropped")
+	}
+	if resB.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", resB.Err())
+	}
+
+	close(gen.unblock)
+	<-resA.Done()
+	select {
+	case src := <-gen.started:
+		t.Errorf("abandoned task should not be started, but %s was generated", src)
+	default:
+	}
+	if tiB.Exists() {
+		t.Error("abandoned thumbnail should not exist")
+	}
+
+	// The abandoned task must not prevent a new request for the same thumbnail.
+	resB, err = estl.Enqueue(tiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-resB.Done()
+	if err := resB.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if !tiB.Exists() {
+		t.Error("thumbnail should exist")
+	}
+}
+
+func TestEnqueueContextAbandonFreesQueue(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithWorkers(1), WithGenerator(gen), WithBufferSize(2), WithClientQuota(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg", "c.jpg", "d.jpg")
+	tis := make([]ThumbInfo, len(srcs))
+	for i, src := range srcs {
+		tis[i], _ = estl.NewThumbInfo(src, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	}
+
+	// Occupy the only worker.
+	resA, err := estl.Enqueue(tis[0], WithClient("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+	resD, err := estl.Enqueue(tis[3], WithClient("bob")) // Another client, so that the quota applies
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Fill the queue and the quota of the client, then abandon it.
+	ctx, cancel := context.WithCancel(context.Background())
+	resB, err := estl.EnqueueContext(ctx, tis[1], WithClient("alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := estl.Enqueue(tis[2], WithClient("alice")); err != ErrEstelleClientQuota {
+		t.Fatalf("expected ErrEstelleClientQuota, got %v", err)
+	}
+	cancel()
+
+	// The abandoned task is dropped immediately, while the worker is still busy.
+	select {
+	case <-resB.Done():
+	case <-time.After(time.Second):
+		t.Fatal("abandoned task was not dropped")
+	}
+	if resB.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", resB.Err())
+	}
+	if queued, _ := estl.runner.Load(); queued != 1 {
+		t.Errorf("expected only the task of the other client queued, got %d queued", queued)
+	}
+	resC, err := estl.Enqueue(tis[2], WithClient("alice"))
+	if err != nil {
+		t.Fatalf("abandoned task should free its queue and quota slot, got %v", err)
+	}
+
+	close(gen.unblock)
+	for _, res := range []*Result{resA, resC, resD} {
+		<-res.Done()
+		if err := res.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tis[1].Exists() {
+		t.Error("abandoned thumbnail should not exist")
+	}
+}
+
+func TestEnqueueContextSharedWaiters(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithWorkers(1), WithGenerator(gen), WithCancelAbandoned(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	res1, err := estl.EnqueueContext(ctx1, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res2, err := estl.EnqueueContext(ctx2, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res1 != res2 {
+		t.Fatal("expected the same Result for the same thumbnail")
+	}
+	<-gen.started
+
+	// One waiter remains, so the task must keep running.
+	cancel1()
+	deadline := time.Now().Add(time.Second)
+	for waiters(res1) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 1 waiter, got %d", waiters(res1))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-res2.Done():
+		t.Fatal("task should not be cancelled while a waiter remains")
+	default:
+	}
+
+	close(gen.unblock)
+	<-res2.Done()
+	if err := res2.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnqueueContextCancelRunning(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithWorkers(1), WithGenerator(gen), WithCancelAbandoned(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res, err := estl.EnqueueContext(ctx, ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+	cancel()
+
+	select {
+	case <-res.Done():
+	case <-time.After(time.Second):
+		t.Fatal("running task was not cancelled")
+	}
+	if res.Err() != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", res.Err())
+	}
+}
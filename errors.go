@@ -0,0 +1,190 @@
+package estelle
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// Kinds of generation failures. Use errors.Is to check the kind of an error returned by
+// Result.Err or Enqueue, and errors.As with *GenerationError to get the details.
+var (
+	// ErrUnsupportedFormat means that the source is not an image, or its format
+	// (or the requested output format) is not supported by the Generator.
+	ErrUnsupportedFormat = fmt.Errorf("unsupported image format")
+	// ErrCorruptSource means that the source image is broken or truncated.
+	ErrCorruptSource = fmt.Errorf("corrupt source image")
+	// ErrPermissionDenied means that the source cannot be read, or the thumbnail cannot be written,
+	// due to the file permission.
+	ErrPermissionDenied = fmt.Errorf("permission denied")
+	// ErrDiskFull means that there is no space left on the device to write the thumbnail.
+	ErrDiskFull = fmt.Errorf("no space left on device")
+	// ErrGeneratorMissing means that the Generator is not available, e.g. `vipsthumbnail` is not installed.
+	ErrGeneratorMissing = fmt.Errorf("thumbnail generator is missing")
+)
+
+// GenerationError is returned (wrapped) by Result.Err when the thumbnail generation fails.
+// Errors returned by a Generator are wrapped into GenerationError unless they are already.
+type GenerationError struct {
+	Kind   error  // One of ErrUnsupportedFormat, ErrCorruptSource, etc., or nil if unknown
+	Source string // Absolute path to the source file
+	Stderr string // Standard error output of the generator command, if any
+	Err    error  // Underlying error
+}
+
+func (e *GenerationError) Error() string {
+	msg := e.Err.Error()
+	if e.Kind != nil {
+		msg = e.Kind.Error() + ": " + msg
+	}
+	if e.Stderr != "" {
+		msg += ": " + e.Stderr
+	}
+	return msg
+}
+
+func (e *GenerationError) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the kind of this error.
+func (e *GenerationError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// transient reports whether the failure is expected to go away without modifying the source,
+// so that it must not be remembered by the negative cache.
+func (e *GenerationError) transient() bool {
+	switch e.Kind {
+	case ErrPermissionDenied, ErrDiskFull, ErrGeneratorMissing, ErrGenerationTimeout:
+		return true
+	}
+	return false
+}
+
+// errorKinds are the kinds of errors with their names, used for persistence.
+// This is a slice rather than a map, so that classify checks them in a fixed order.
+var errorKinds = []struct {
+	name string
+	kind error
+}{
+	{"unsupported_format", ErrUnsupportedFormat},
+	{"corrupt_source", ErrCorruptSource},
+	{"permission_denied", ErrPermissionDenied},
+	{"disk_full", ErrDiskFull},
+	{"generator_missing", ErrGeneratorMissing},
+	{"timeout", ErrGenerationTimeout},
+}
+
+func kindName(kind error) string {
+	for _, k := range errorKinds {
+		if k.kind == kind {
+			return k.name
+		}
+	}
+	return ""
+}
+
+func kindByName(name string) error {
+	for _, k := range errorKinds {
+		if k.name == name {
+			return k.kind
+		}
+	}
+	return nil
+}
+
+// decodeError wraps a failure of decoding the source image.
+// Only the messages of decodeError and stderr of vipsthumbnail are classified by their texts,
+// since other errors, e.g. of invalid parameters, may contain the same words by chance.
+type decodeError struct {
+	err error
+}
+
+func (e *decodeError) Error() string { return e.err.Error() }
+func (e *decodeError) Unwrap() error { return e.err }
+
+// Messages of libvips (and libjpeg, libpng, etc. used by it) in stderr of vipsthumbnail.
+var (
+	vipsUnsupportedMessages = []string{
+		"is not a known file format",
+		"unsupported jpeg process",
+		"unsupported marker type",
+		"unsupported image format",
+	}
+	vipsCorruptMessages = []string{
+		"premature end of jpeg file",
+		"corrupt jpeg data",
+		"invalid jpeg file structure",
+		"not a jpeg file",
+		"libpng read error",
+		"crc error",
+		"out of order read",
+		"truncated",
+		"read error",
+	}
+)
+
+// classify guesses the kind of a generation failure from the error and stderr of the generator.
+// It returns nil if the kind is unknown.
+func classify(err error, stderr string) error {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.kind) {
+			return k.kind // Already classified by the Generator
+		}
+	}
+	var jpegFormat jpeg.FormatError
+	var jpegUnsupported jpeg.UnsupportedError
+	var pngFormat png.FormatError
+	var pngUnsupported png.UnsupportedError
+	switch {
+	case errors.Is(err, exec.ErrNotFound):
+		return ErrGeneratorMissing
+	case errors.Is(err, syscall.ENOSPC):
+		return ErrDiskFull
+	case errors.Is(err, fs.ErrPermission):
+		return ErrPermissionDenied
+	case errors.Is(err, image.ErrFormat), errors.As(err, &jpegUnsupported), errors.As(err, &pngUnsupported):
+		return ErrUnsupportedFormat
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &jpegFormat), errors.As(err, &pngFormat):
+		return ErrCorruptSource
+	}
+
+	msg := strings.ToLower(stderr)
+	switch {
+	case strings.Contains(msg, "no space left on device"):
+		return ErrDiskFull
+	case strings.Contains(msg, "permission denied"):
+		return ErrPermissionDenied
+	case containsAny(msg, vipsUnsupportedMessages):
+		return ErrUnsupportedFormat
+	case containsAny(msg, vipsCorruptMessages):
+		return ErrCorruptSource
+	}
+
+	// Other decoders of Go, e.g. GIF and WebP, return errors with plain messages.
+	var de *decodeError
+	if errors.As(err, &de) && !errors.Is(err, fs.ErrNotExist) {
+		if msg := strings.ToLower(de.Error()); strings.Contains(msg, "unsupported") || strings.Contains(msg, "not supported") {
+			return ErrUnsupportedFormat
+		}
+		return ErrCorruptSource
+	}
+	return nil
+}
+
+func containsAny(s string, substrs []string) bool {
+	for _, sub := range substrs {
+		if strings.Contains(s, sub) {
+			return true
+		}
+	}
+	return false
+}
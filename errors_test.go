@@ -0,0 +1,87 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		stderr string
+		want   error
+	}{
+		{"already classified", fmt.Errorf("%w: webp", ErrUnsupportedFormat), "", ErrUnsupportedFormat},
+		{"command not found", &exec.Error{Name: "vipsthumbnail", Err: exec.ErrNotFound}, "", ErrGeneratorMissing},
+		{"ENOSPC", &os.PathError{Op: "write", Path: "x", Err: syscall.ENOSPC}, "", ErrDiskFull},
+		{"EACCES", &os.PathError{Op: "open", Path: "x", Err: syscall.EACCES}, "", ErrPermissionDenied},
+		{"image.ErrFormat", fmt.Errorf("failed to decode: %w", image.ErrFormat), "", ErrUnsupportedFormat},
+		{"unexpected EOF", fmt.Errorf("failed to decode: %w", io.ErrUnexpectedEOF), "", ErrCorruptSource},
+		{"vips unknown format", errors.New("exit status 1"), `VipsForeignLoad: "/tmp/a.jpg" is not a known file format`, ErrUnsupportedFormat},
+		{"vips truncated", errors.New("exit status 1"), "VipsJpeg: Premature end of JPEG file", ErrCorruptSource},
+		{"jpeg format error", fmt.Errorf("failed to decode: %w", jpeg.FormatError("bad RST marker")), "", ErrCorruptSource},
+		{"png unsupported", fmt.Errorf("failed to decode: %w", png.UnsupportedError("color type")), "", ErrUnsupportedFormat},
+		{"gif decode error", &decodeError{errors.New("gif: not enough image data")}, "", ErrCorruptSource},
+		{"webp unsupported", &decodeError{errors.New("webp: unsupported feature")}, "", ErrUnsupportedFormat},
+		{"invalid parameter", errors.New("invalid thumbnail size: 0x0"), "", nil},
+		{"unsupported outside decoding", errors.New("unsupported operation"), "", nil},
+		{"unknown", errors.New("exit status 1"), "something went wrong", nil},
+		{"vips invalid argument", errors.New("exit status 1"), "vips_thumbnail: invalid argument", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classify(tt.err, tt.stderr); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestGenerationErrorKind(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "broken.jpg")
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		return fmt.Errorf("failed to decode %s: %w", source, io.ErrUnexpectedEOF)
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if !errors.Is(res.Err(), ErrCorruptSource) {
+		t.Errorf("expected ErrCorruptSource, got %v", res.Err())
+	}
+	var ge *GenerationError
+	if !errors.As(res.Err(), &ge) || ge.Source != srcs[0] {
+		t.Errorf("expected GenerationError with source %s, got %#v", srcs[0], res.Err())
+	}
+	if errors.Is(res.Err(), ErrUnsupportedFormat) {
+		t.Errorf("should not match other kinds")
+	}
+
+	// The kind is kept in the negative cache.
+	_, err = estl.Enqueue(ti)
+	var cfe *CachedFailureError
+	if !errors.As(err, &cfe) || !errors.Is(err, ErrCorruptSource) {
+		t.Errorf("expected cached ErrCorruptSource, got %v", err)
+	}
+}
@@ -4,11 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"os"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/Maki-Daisuke/go-filiq"
 	cmap "github.com/orcaman/concurrent-map/v2"
 )
 
@@ -18,40 +17,49 @@ var ErrEstelleClosed = fmt.Errorf("estelle is already shutdown")
 // ErrEstelleQueueFull is returned when the internal task queue is full.
 var ErrEstelleQueueFull = fmt.Errorf("estelle queue is full")
 
-// Result represents the status of an enqueued task.
-// It follows a context-like pattern allowing select-based waiting.
-type Result struct {
-	done chan struct{} // Closed when the task finishes
-	err  error         // The resulting error, valid only after done is closed
-}
+// ErrGenerationTimeout is the kind of *GenerationError returned by Result.Err when the thumbnail
+// generation exceeds the timeout set by WithGenerationTimeout. Use errors.Is to check it.
+var ErrGenerationTimeout = fmt.Errorf("thumbnail generation timed out")
 
-// Done returns a channel that's closed when the task completes.
-// This allows the Result to be used in select statements.
-func (r *Result) Done() <-chan struct{} {
-	return r.done
-}
+// ErrEstelleClientQuota is returned when the client has already queued as many tasks as its quota.
+var ErrEstelleClientQuota = fmt.Errorf("estelle client quota is exceeded")
 
-// Err returns the error resulting from the task.
-// It is only safe to call after the Done channel is closed.
-func (r *Result) Err() error {
-	return r.err
-}
+// ErrNotCached is returned by Lookup when the thumbnail does not exist in the cache.
+var ErrNotCached = fmt.Errorf("thumbnail is not cached")
 
 // Estelle is the main thumbnail generation engine that manages the queue, worker pool, and garbage collection.
 type Estelle struct {
-	dir          ThumbInfoFactory
-	runner       *filiq.Runner
-	gc           *garbageCollector
-	pendingTasks atomic.Pointer[cmap.ConcurrentMap[string, *Result]]
+	dir             ThumbInfoFactory
+	gen             Generator
+	cancelAbandoned bool
+	genTimeout      time.Duration
+	maxFileSize     int64
+	maxPixels       int64
+	negativeTTL     time.Duration
+	derive          bool
+	runner          *scheduler
+	gc              *garbageCollector
+	events          eventHub
+	stats           stats
+	pendingTasks    atomic.Pointer[cmap.ConcurrentMap[string, *Result]]
 }
 
 type config struct {
-	cacheLimit   int64
-	gcHighRatio  float64
-	gcLowRatio   float64
-	workerNum    int
-	bufferSize   int
-	panicHandler func(interface{})
+	cacheLimit      int64
+	gcHighRatio     float64
+	gcLowRatio      float64
+	workerNum       int
+	bufferSize      int
+	clientQuota     int
+	starvationLimit int
+	panicHandler    func(interface{})
+	generator       Generator
+	cancelAbandoned bool
+	genTimeout      time.Duration
+	maxFileSize     int64
+	maxPixels       int64
+	negativeTTL     time.Duration
+	derive          bool
 }
 
 // Option defines a functional option for configuring an Estelle instance.
@@ -86,6 +94,24 @@ func WithBufferSize(size int) Option {
 	}
 }
 
+// WithClientQuota sets the maximum number of queued tasks per client (see WithClient), which is
+// applied only while other clients also have queued tasks. This prevents a single client from
+// crowding out the others, while a client alone can still use the whole queue. 0 means unlimited.
+func WithClientQuota(n int) Option {
+	return func(c *config) {
+		c.clientQuota = n
+	}
+}
+
+// WithStarvationLimit sets how many interactive tasks may run in a row while background tasks are waiting.
+// After that, one background task runs even if interactive tasks are queued. 1 means that
+// interactive and background tasks run alternately. n less than 1 is regarded as 1.
+func WithStarvationLimit(n int) Option {
+	return func(c *config) {
+		c.starvationLimit = max(n, 1)
+	}
+}
+
 // WithPanicHandler sets a custom panic handler for the thumbnail generation workers.
 func WithPanicHandler(h func(interface{})) Option {
 	return func(c *config) {
@@ -93,6 +119,72 @@ func WithPanicHandler(h func(interface{})) Option {
 	}
 }
 
+// WithGenerator sets the Generator used to make thumbnails.
+// The default is DefaultGenerator(), that is, VipsGenerator if `vipsthumbnail` command
+// is available, otherwise GoGenerator.
+func WithGenerator(g Generator) Option {
+	return func(c *config) {
+		c.generator = g
+	}
+}
+
+// WithCancelAbandoned makes Estelle cancel a running task when all callers of EnqueueContext
+// waiting for it have gone, that is, their contexts are done.
+// A queued task which has been abandoned is always removed from the queue at once, regardless of this option.
+func WithCancelAbandoned(enable bool) Option {
+	return func(c *config) {
+		c.cancelAbandoned = enable
+	}
+}
+
+// WithGenerationTimeout sets the maximum duration of a single thumbnail generation.
+// When thumbnails are generated at once by MultiGenerator, the limit is multiplied by their number.
+// When it expires, the Generator is cancelled (VipsGenerator kills the process group of vipsthumbnail)
+// and the Result fails with ErrGenerationTimeout. 0 means no timeout.
+func WithGenerationTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.genTimeout = d
+	}
+}
+
+// WithMaxFileSize sets the maximum size in bytes of source files.
+// Enqueue rejects larger sources with *SourceTooLargeError. 0 means unlimited.
+func WithMaxFileSize(n int64) Option {
+	return func(c *config) {
+		c.maxFileSize = n
+	}
+}
+
+// WithMaxPixels sets the maximum number of pixels (width * height) of source images.
+// Enqueue reads the header of the source and rejects larger images with *SourceTooLargeError
+// before generating the thumbnail. 0 means unlimited.
+func WithMaxPixels(n int64) Option {
+	return func(c *config) {
+		c.maxPixels = n
+	}
+}
+
+// WithNegativeCacheTTL enables the negative cache, which remembers failures of thumbnail generation
+// for the given duration. While a failure is cached, Enqueue for the same thumbnail immediately
+// returns *CachedFailureError without running the Generator again.
+// The cache is stored in the cache directory, so it survives restarts.
+// 0 disables the negative cache, which is the default.
+func WithNegativeCacheTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.negativeTTL = d
+	}
+}
+
+// WithDerivation makes Estelle generate a thumbnail from a larger cached thumbnail of the same source
+// with a compatible mode, if any, instead of decoding the source, which may be much larger.
+// This is much faster for large sources, at the cost of a slight quality loss by encoding twice.
+// The default is false.
+func WithDerivation(enable bool) Option {
+	return func(c *config) {
+		c.derive = enable
+	}
+}
+
 // New creates a new Estelle instance.
 // It initializes the underlying directory structure, worker pool, and garbage collection.
 func New(path string, opts ...Option) (*Estelle, error) {
@@ -103,31 +195,33 @@ func New(path string, opts ...Option) (*Estelle, error) {
 
 	cfg := config{
 		// Default values
-		cacheLimit:  1024 * 1024 * 1024, // 1GB default
-		gcHighRatio: 0.90,
-		gcLowRatio:  0.75,
-		workerNum:   1, // Safe default
-		bufferSize:  1024,
+		cacheLimit:      1024 * 1024 * 1024, // 1GB default
+		gcHighRatio:     0.90,
+		gcLowRatio:      0.75,
+		workerNum:       1, // Safe default
+		bufferSize:      1024,
+		starvationLimit: 8,
 	}
 
 	for _, opt := range opts {
 		opt(&cfg)
 	}
-
-	filiqOpts := []filiq.Option{
-		filiq.WithLIFO(),
-		filiq.WithWorkers(cfg.workerNum),
-		filiq.WithBufferSize(cfg.bufferSize),
-	}
-	if cfg.panicHandler != nil {
-		filiqOpts = append(filiqOpts, filiq.WithPanicHandler(cfg.panicHandler))
+	if cfg.generator == nil {
+		cfg.generator = DefaultGenerator()
 	}
 
 	estl := &Estelle{
-		dir:    dir,
-		runner: filiq.New(filiqOpts...),
-		gc:     newGarbageCollector(dir.BaseDir(), cfg.cacheLimit, cfg.gcHighRatio, cfg.gcLowRatio),
+		dir:             dir,
+		gen:             cfg.generator,
+		cancelAbandoned: cfg.cancelAbandoned,
+		genTimeout:      cfg.genTimeout,
+		maxFileSize:     cfg.maxFileSize,
+		maxPixels:       cfg.maxPixels,
+		negativeTTL:     cfg.negativeTTL,
+		derive:          cfg.derive,
+		runner:          newScheduler(cfg.workerNum, cfg.bufferSize, cfg.clientQuota, cfg.starvationLimit, cfg.panicHandler),
 	}
+	estl.gc = newGarbageCollector(dir.BaseDir(), cfg.cacheLimit, cfg.gcHighRatio, cfg.gcLowRatio, estl.evicted)
 	cm := cmap.New[*Result]()
 	estl.pendingTasks.Store(&cm)
 	return estl, nil
@@ -135,6 +229,7 @@ func New(path string, opts ...Option) (*Estelle, error) {
 
 // Shutdown gracefully stops the thumbnail generation workers and the garbage collector.
 // It closes the channels of all pending tasks to unblock any waiting clients.
+// If ctx expires before the running tasks finish, their Generators are cancelled.
 func (estl *Estelle) Shutdown(ctx context.Context) error {
 	pending := estl.pendingTasks.Swap(nil)
 	if pending == nil {
@@ -144,13 +239,19 @@ func (estl *Estelle) Shutdown(ctx context.Context) error {
 	wg.Add(2)
 	go func() {
 		defer wg.Done()
-		estl.runner.Shutdown(ctx)
+		if err := estl.runner.Shutdown(ctx); err != nil {
+			// Kill in-flight generators not to leave them running after shutdown.
+			for _, r := range pending.Items() {
+				r.abort(ErrEstelleClosed)
+			}
+		}
 	}()
 	go func() {
 		defer wg.Done()
 		estl.gc.Shutdown(ctx)
 	}()
 	wg.Wait()
+	estl.events.close()
 	// Close channels of all pending tasks so that any goroutine waiting on them will unblock.
 	for _, k := range pending.Keys() {
 		r, ok := pending.Pop(k)
@@ -167,102 +268,338 @@ func (estl *Estelle) NewThumbInfo(path string, size Size, mode Mode, format Form
 	return estl.dir.FromFile(path, size, mode, format)
 }
 
-// closedResult is a Result that is already closed.
-// We reuse this for optimization for the case where the thumbnail already exists.
-var closedResult = &Result{done: func() chan struct{} {
-	ch := make(chan struct{})
-	close(ch)
-	return ch
-}()}
+// EnqueueOption defines a functional option for Enqueue and EnqueueContext.
+type EnqueueOption func(*enqueueConfig)
+
+type enqueueConfig struct {
+	priority Priority
+	client   string
+}
+
+// WithPriority sets the priority of the task. The default is PriorityInteractive.
+// If the task for the same thumbnail is already queued with lower priority, it is promoted.
+func WithPriority(p Priority) EnqueueOption {
+	return func(c *enqueueConfig) {
+		c.priority = p
+	}
+}
+
+// WithClient sets the identity of the client requesting the task.
+// Queued tasks are scheduled in round-robin order across clients, and the number of
+// queued tasks per client is limited by WithClientQuota.
+// The default is the empty string, that is, all tasks without this option are
+// regarded as requested by the same anonymous client.
+func WithClient(id string) EnqueueOption {
+	return func(c *enqueueConfig) {
+		c.client = id
+	}
+}
 
 // Enqueue submits a thumbnail generation task to the queue.
+// It is equivalent to EnqueueContext with context.Background(),
+// that is, the task is never abandoned.
+func (estl *Estelle) Enqueue(ti ThumbInfo, opts ...EnqueueOption) (*Result, error) {
+	return estl.EnqueueContext(context.Background(), ti, opts...)
+}
+
+// EnqueueContext submits a thumbnail generation task to the queue.
 // It returns a newly created `*Result` object and a possible immediate error.
 // If the thumbnail already exists, it returns `(res, nil)`.
 // If the task is queued (or already pending), it returns `(res, nil)`.
-// If the queue is full or already closed, it returns `(nil, error)`.
-func (estl *Estelle) Enqueue(ti ThumbInfo) (*Result, error) {
-	if ti.Exists() {
-		return closedResult, nil
+// If the queue is full, the client quota is exceeded or Estelle is already closed,
+// it returns `(nil, error)`.
+// If the source exceeds the limits set by WithMaxFileSize or WithMaxPixels,
+// it returns `(nil, *SourceTooLargeError)`.
+// If the previous generation failed and the failure is cached (see WithNegativeCacheTTL),
+// it returns `(nil, *CachedFailureError)`.
+//
+// The caller is regarded as waiting for the Result until ctx is done.
+// When all callers waiting for the same task have gone before the task starts,
+// the task is dropped without being executed. If WithCancelAbandoned is enabled,
+// the task is cancelled even while it is running.
+func (estl *Estelle) EnqueueContext(ctx context.Context, ti ThumbInfo, opts ...EnqueueOption) (*Result, error) {
+	var cfg enqueueConfig
+	for _, opt := range opts {
+		opt(&cfg)
 	}
+	j := &job{priority: cfg.priority, client: cfg.client}
+	res, isNew, err := estl.prepare(ctx, ti, j)
+	if err != nil || !isNew {
+		return res, err
+	}
+	if err := estl.submit(j, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
 
-	res := &Result{done: make(chan struct{})}
-	key := ti.String()
+// prepare returns the Result for ti, registering ctx as its waiter.
+// If a new task is needed, the Result is registered as pending with j and isNew is true;
+// then the caller must submit j by estl.submit.
+func (estl *Estelle) prepare(ctx context.Context, ti ThumbInfo, j *job) (res *Result, isNew bool, err error) {
+	if ti.Exists() {
+		if meta, err := ti.Metadata(); err == nil {
+			estl.stats.hits.Add(1)
+			return cachedResult(meta), false, nil
+		}
+		// Evicted just now. Generate it again.
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	if err := estl.lookupFailure(ti); err != nil {
+		return nil, false, err
+	}
+	if err := checkSource(ti.source, estl.maxFileSize, estl.maxPixels); err != nil {
+		return nil, false, err
+	}
 
 	pending := estl.pendingTasks.Load()
 	if pending == nil {
-		return nil, ErrEstelleClosed
+		return nil, false, ErrEstelleClosed
 	}
 
+	res = newResult()
+	res.ti = ti
+	key := ti.String()
+	res.job = j
+	res.onAbandon = func() { estl.dropAbandoned(j) }
+	// Register ourselves as a waiter before the Result gets visible to others.
+	res.acquire(ctx, estl.cancelAbandoned)
+
 	// Try to set new task as pending
 	if pending.SetIfAbsent(key, res) {
 		// We successfully registered a new task.
-		task := estl.makeTask(ti, res)
-		if err := estl.runner.Submit(task); err != nil {
-			// Runner closed or Queue full
-			pending.Remove(key) // cleanup
-			if errors.Is(err, filiq.ErrQueueClosed) {
-				res.err = ErrEstelleClosed
-			} else if errors.Is(err, filiq.ErrQueueFull) {
-				res.err = ErrEstelleQueueFull
-			} else {
-				res.err = err
-			}
-			tryClose(res.done) // Unblock any listeners (just in case)
-			// The channel may be closed already by Shutdown.
-			return nil, res.err
-		}
-		// Return the waitable Result struct
-		return res, nil
+		estl.stats.misses.Add(1)
+		return res, true, nil
 	}
+	res.finish() // Discard our Result, which nobody knows
 
 	// Task is already pending or running. Return the existing Result.
 	if actual, ok := pending.Get(key); ok {
-		return actual, nil
+		if actual.acquire(ctx, estl.cancelAbandoned) {
+			estl.runner.Promote(actual.job, j.priority)
+			estl.stats.coalesced.Add(1)
+			return actual, false, nil
+		}
+		// The task has been abandoned by all the other waiters. Replace it with a new one.
+		removePending(pending, key, actual)
+		return estl.prepare(ctx, ti, j)
 	}
 
 	// Race condition edge case:
 	// SetIfAbsent returned false (key existed), but Get returned false (key removed).
-	// This means the task JUST finished. Let's retry Enqueue (will hit Exists() fast path).
-	return estl.Enqueue(ti)
-}
-
-// makeTask creates a thunk that executes the thumbnail generation.
-func (estl *Estelle) makeTask(ti ThumbInfo, res *Result) func() {
-	return func() {
-		defer func() {
-			if r := recover(); r != nil {
-				if e, ok := r.(error); ok {
-					res.err = e
-				} else {
-					res.err = fmt.Errorf("panic: %v", r)
-				}
+	// This means the task JUST finished. Let's retry (will hit Exists() fast path).
+	return estl.prepare(ctx, ti, j)
+}
+
+// submit queues j which generates the thumbnails of tasks prepared with j.
+// If it fails, the tasks are completed with the error.
+func (estl *Estelle) submit(j *job, tasks ...*Result) error {
+	j.tasks = tasks
+	j.fn = func() { estl.runTasks(j.tasks) }
+	err := estl.runner.Submit(j)
+	if err != nil {
+		// Runner closed, Queue full or quota exceeded
+		switch err {
+		case ErrEstelleQueueFull:
+			estl.stats.queueFull.Add(1)
+		case ErrEstelleClientQuota:
+			estl.stats.quotaExceeded.Add(1)
+		}
+		for _, res := range tasks {
+			if pending := estl.pendingTasks.Load(); pending != nil {
+				removePending(pending, res.ti.String(), res) // cleanup
+			}
+			res.err = err
+			res.finish() // Unblock any listeners (just in case)
+		}
+		return err
+	}
+	// The waiters may have gone before j was queued.
+	estl.dropAbandoned(j)
+	return nil
+}
+
+// dropAbandoned removes j from the queue if all of its tasks have been abandoned, and completes
+// them as cancelled. Otherwise, abandoned jobs would occupy the queue and the client quota until
+// a worker pops them, which may take long since the queue of each client is LIFO.
+func (estl *Estelle) dropAbandoned(j *job) {
+	if !estl.runner.Drop(j) {
+		return
+	}
+	for _, res := range j.tasks {
+		res.err = context.Canceled
+		estl.complete(res, false)
+	}
+}
+
+// Lookup returns the metadata of the thumbnail if it exists in the cache. It never generates
+// the thumbnail. pending reports whether the generation is queued or running at the moment.
+// If the thumbnail does not exist, it returns ErrNotCached, or *CachedFailureError if the last
+// generation failed.
+func (estl *Estelle) Lookup(ti ThumbInfo) (meta Metadata, pending bool, err error) {
+	if ti.Exists() {
+		if meta, err := ti.Metadata(); err == nil {
+			meta.CacheHit = true
+			return meta, false, nil
+		}
+	}
+	if p := estl.pendingTasks.Load(); p != nil {
+		pending = p.Has(ti.String())
+	}
+	if err := estl.lookupFailure(ti); err != nil && !pending {
+		return Metadata{}, false, err
+	}
+	return Metadata{}, pending, ErrNotCached
+}
+
+// runTasks generates the thumbnails of tasks, which are all for the same source.
+// If the Generator implements MultiGenerator, they are generated at once together with the other
+// queued tasks for the same source. Otherwise, they are generated one by one.
+func (estl *Estelle) runTasks(tasks []*Result) {
+	mg, multi := estl.gen.(MultiGenerator)
+	if multi {
+		tasks = estl.coalesce(tasks)
+	}
+	completed := make([]bool, len(tasks))
+	defer func() {
+		var perr error
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				perr = e
+			} else {
+				perr = fmt.Errorf("panic: %v", r)
 			}
-			close(res.done)
-			pending := estl.pendingTasks.Load()
-			if pending != nil {
-				pending.Remove(ti.String())
+		}
+		for i, res := range tasks {
+			if !completed[i] {
+				if perr != nil {
+					res.err = perr
+				}
+				estl.complete(res, false)
 			}
-		}()
+		}
+	}()
+
+	var batch []*Result
+	var ctxs []context.Context
+	for i, res := range tasks {
+		ctx, ok := res.start()
+		switch {
+		case !ok:
+			// Nobody is waiting for this task anymore.
+			res.err = context.Canceled
+		case res.ti.Exists():
+			res.meta, res.err = res.ti.Metadata()
+			res.meta.CacheHit = true
+		case estl.derive && estl.makeDerived(ctx, res):
+			completed[i] = true
+			estl.complete(res, res.err == nil)
+			continue
+		case multi:
+			batch = append(batch, res)
+			ctxs = append(ctxs, ctx)
+			continue
+		default:
+			estl.generate(ctx, []*Result{res}, func(ctx context.Context) []error {
+				return []error{res.ti.make(ctx, estl.gen)}
+			})
+			completed[i] = true
+			estl.complete(res, res.err == nil)
+			continue
+		}
+		completed[i] = true
+		estl.complete(res, false)
+	}
+	if len(batch) == 0 {
+		return
+	}
 
-		if ti.Exists() {
-			return
+	// Generation goes on while anyone is waiting for any of the thumbnails.
+	ctx, cancel := mergeContexts(ctxs)
+	defer cancel()
+	tis := make([]ThumbInfo, len(batch))
+	for i, res := range batch {
+		tis[i] = res.ti
+	}
+	estl.generate(ctx, batch, func(ctx context.Context) []error {
+		if len(tis) == 1 {
+			return []error{tis[0].make(ctx, estl.gen)}
 		}
-		if err := ti.make(); err != nil {
+		return makeAll(ctx, mg, tis)
+	})
+	for i, res := range tasks {
+		if !completed[i] {
+			completed[i] = true
+			estl.complete(res, res.err == nil)
+		}
+	}
+}
+
+// generate calls fn with the generation timeout, and sets the result of each task according to
+// the errors returned by fn in the same order as tasks.
+// The timeout is multiplied by the number of tasks, since it is for a single thumbnail.
+func (estl *Estelle) generate(ctx context.Context, tasks []*Result, fn func(ctx context.Context) []error) {
+	begin := time.Now()
+	timeout := estl.genTimeout * time.Duration(len(tasks))
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, timeout, ErrGenerationTimeout)
+		defer cancel()
+	}
+	errs := fn(ctx)
+	for i, res := range tasks {
+		if err := errs[i]; err != nil {
+			if context.Cause(ctx) == ErrGenerationTimeout && !errors.Is(err, ErrGenerationTimeout) {
+				err = &GenerationError{
+					Kind:   ErrGenerationTimeout,
+					Source: res.ti.source,
+					Err:    fmt.Errorf("after %s: %w", timeout, err),
+				}
+			}
+			// Cache only failures caused by the source, not by cancellation, timeout, etc.
+			var ge *GenerationError
+			if ctx.Err() == nil && errors.As(err, &ge) && !ge.transient() {
+				estl.recordFailure(res.ti, ge)
+			}
+			if ctx.Err() == nil || context.Cause(ctx) == ErrGenerationTimeout {
+				estl.stats.failed.Add(1)
+			}
 			res.err = err
-			return
+			continue
 		}
-		st, err := os.Stat(ti.Path())
+		meta, err := res.ti.Metadata()
 		if err != nil {
 			res.err = err
-			return
+			continue
 		}
-		estl.gc.Track(st.Size())
+		estl.gc.Track(meta.Bytes)
+		meta.QueueTime = begin.Sub(res.enqueuedAt)
+		meta.GenerateTime = time.Since(begin)
+		res.meta = meta
+		estl.stats.generated.Add(1)
+		estl.stats.observe(FormatMode{res.ti.format, res.ti.mode}, meta.GenerateTime)
 	}
 }
 
-func tryClose(ch chan struct{}) {
-	defer func() {
-		recover()
-	}()
-	close(ch) // calling close on a closed channel panics
+// complete finishes res and notifies the subscribers.
+func (estl *Estelle) complete(res *Result, generated bool) {
+	res.finish()
+	pending := estl.pendingTasks.Load()
+	if pending != nil {
+		removePending(pending, res.ti.String(), res)
+	}
+	if generated && res.err == nil {
+		estl.recordSource(res.ti)
+	}
+	estl.publishResult(res.ti, res, generated)
+}
+
+// removePending removes res from pending only if it is still registered with key.
+// The key may have been already taken over by a new task, which must not be removed.
+func removePending(pending *cmap.ConcurrentMap[string, *Result], key string, res *Result) {
+	pending.RemoveCb(key, func(_ string, v *Result, exists bool) bool {
+		return exists && v == res
+	})
 }
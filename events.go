@@ -0,0 +1,174 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the type of Event.
+type EventType int
+
+const (
+	// EventGenerated is emitted when a thumbnail is generated.
+	EventGenerated EventType = iota + 1
+	// EventFailed is emitted when the generation of a thumbnail fails.
+	EventFailed
+	// EventEvicted is emitted when a thumbnail is removed from the cache by GC.
+	EventEvicted
+)
+
+// String returns the string representation of the event type.
+func (t EventType) String() string {
+	switch t {
+	case EventGenerated:
+		return "generated"
+	case EventFailed:
+		return "failed"
+	case EventEvicted:
+		return "evicted"
+	}
+	panic(fmt.Sprintf("Unknown event type: %d", t))
+}
+
+// Event notifies a change of a thumbnail in the cache.
+type Event struct {
+	Type   EventType
+	ID     string    // ID of the thumbnail (ThumbInfo.String())
+	Source string    // Absolute path to the source file. It may be empty for EventEvicted if the record of the source is lost.
+	Path   string    // Absolute path to the thumbnail
+	Time   time.Time // When the event happened
+
+	Metadata Metadata // Metadata of the generated thumbnail, for EventGenerated
+	Err      error    // The error, for EventFailed
+}
+
+// eventHub delivers events to the subscribers.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// Subscribe returns a channel to receive events until ctx is done or Estelle is shut down,
+// then the channel is closed. If the subscriber does not keep up with more than buffer events,
+// it is unsubscribed and the channel is closed too, rather than blocking thumbnail generation
+// or losing events silently. Then, the subscriber should subscribe again and resync its state.
+func (estl *Estelle) Subscribe(ctx context.Context, buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	h := &estl.events
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if estl.pendingTasks.Load() == nil {
+		close(ch) // Already shut down
+		return ch
+	}
+	if h.subs == nil {
+		h.subs = map[chan Event]struct{}{}
+	}
+	h.subs[ch] = struct{}{}
+	context.AfterFunc(ctx, func() { h.unsubscribe(ch) })
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// close unsubscribes all the subscribers.
+func (h *eventHub) close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+func (h *eventHub) publish(ev Event) {
+	ev.Time = time.Now()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default: // Slow subscriber. Drop it, so that it knows that events have been lost.
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// publishResult emits the event for the completed task of ti.
+// Tasks which were not run, i.e. cancelled or the thumbnail was already there, are not notified.
+func (estl *Estelle) publishResult(ti ThumbInfo, res *Result, generated bool) {
+	switch {
+	case res.err != nil && !errors.Is(res.err, context.Canceled):
+		estl.events.publish(Event{Type: EventFailed, ID: ti.id, Source: ti.source, Path: ti.path, Err: res.err})
+	case res.err == nil && generated:
+		estl.events.publish(Event{Type: EventGenerated, ID: ti.id, Source: ti.source, Path: ti.path, Metadata: res.meta})
+	}
+}
+
+// evicted is called by GC when a file is removed from the cache.
+func (estl *Estelle) evicted(path string) {
+	ti, err := estl.dir.FromID(filepath.Base(path))
+	if err != nil {
+		return // Not a thumbnail, e.g. a negative cache entry
+	}
+	estl.events.publish(Event{Type: EventEvicted, ID: ti.id, Source: estl.evictedSource(ti), Path: path})
+}
+
+// sourceRecordExt is the extension of the files recording the source of thumbnails.
+const sourceRecordExt = ".source"
+
+// sourceRecordPath returns the path of the file recording the source of the thumbnail.
+// It is shared by all the thumbnails with the same fingerprint hash, which are in the same directory,
+// so that evicted events can tell the source although the thumbnail ID does not contain it.
+func (ti ThumbInfo) sourceRecordPath() string {
+	return filepath.Join(filepath.Dir(ti.path), ti.hash()+sourceRecordExt)
+}
+
+// recordSource saves the source of the generated thumbnail ti, unless it is already recorded.
+func (estl *Estelle) recordSource(ti ThumbInfo) {
+	f, err := os.OpenFile(ti.sourceRecordPath(), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return // Already recorded, or we cannot. Evicted events just lack the source.
+	}
+	defer f.Close()
+	if n, err := f.WriteString(ti.source); err == nil {
+		estl.gc.Track(int64(n))
+	}
+}
+
+// evictedSource returns the source of the evicted thumbnail ti, or "" if it is not recorded.
+// The record is removed together with the last thumbnail of the source.
+func (estl *Estelle) evictedSource(ti ThumbInfo) string {
+	path := ti.sourceRecordPath()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return string(b)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ti.hash()+"-") && !strings.HasSuffix(e.Name(), ".failed") {
+			return string(b) // Still used by another thumbnail
+		}
+	}
+	if os.Remove(path) == nil {
+		estl.gc.Track(-int64(len(b)))
+	}
+	return string(b)
+}
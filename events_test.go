@@ -0,0 +1,160 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg", "broken.jpg")
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		if filepath.Base(source) == "broken.jpg" {
+			return errors.New("broken")
+		}
+		return os.WriteFile(output, make([]byte, 1000), 0644)
+	})
+	// The cache can hold only one thumbnail, so the older one is evicted.
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithCacheLimit(1500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := estl.Subscribe(ctx, 16)
+
+	next := func() Event {
+		t.Helper()
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+		}
+		return Event{}
+	}
+	run := func(ti ThumbInfo) {
+		t.Helper()
+		res, err := estl.Enqueue(ti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-res.Done()
+	}
+
+	ti1, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	ti2, _ := estl.NewThumbInfo(srcs[1], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	ti3, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(200, 200), ModeCrop, FMT_JPG)
+	run(ti1)
+	run(ti2)
+	run(ti3)
+
+	// GC runs concurrently, so the order is not deterministic.
+	got := map[string]Event{}
+	evicted := 0
+	for len(got)-evicted < 3 || evicted == 0 {
+		ev := next()
+		got[ev.Type.String()+" "+ev.ID] = ev
+		if ev.Type == EventEvicted {
+			evicted++
+		}
+	}
+	if ev, ok := got["generated "+ti1.String()]; !ok || ev.Source != srcs[0] || ev.Path != ti1.Path() || ev.Metadata.Bytes != 1000 {
+		t.Errorf("unexpected generated event: %+v", ev)
+	}
+	if ev, ok := got["failed "+ti2.String()]; !ok || ev.Err == nil {
+		t.Errorf("unexpected failed event: %+v", ev)
+	}
+	if _, ok := got["generated "+ti3.String()]; !ok {
+		t.Errorf("missing generated event: %v", got)
+	}
+	ev1, ok1 := got["evicted "+ti1.String()]
+	ev3, ok3 := got["evicted "+ti3.String()]
+	if !ok1 && !ok3 {
+		t.Errorf("missing evicted event: %v", got)
+	}
+	if ok1 && ev1.Source != srcs[0] || ok3 && ev3.Source != srcs[0] {
+		t.Errorf("evicted event should have the source: %v", got)
+	}
+
+	// The channel is closed when ctx is done.
+	cancel()
+	for range events {
+	}
+
+	// Subscribers are closed on shutdown.
+	events = estl.Subscribe(context.Background(), 1)
+	estl.Shutdown(context.Background())
+	if _, ok := <-events; ok {
+		t.Error("channel should be closed on shutdown")
+	}
+}
+
+func TestEvictedSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		return os.WriteFile(output, []byte("thumbnail"), 0644)
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	events := estl.Subscribe(context.Background(), 16)
+
+	ti1, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	ti2, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(200, 200), ModeCrop, FMT_JPG)
+	for _, ti := range []ThumbInfo{ti1, ti2} {
+		res, err := estl.Enqueue(ti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-res.Done()
+		if ev := <-events; ev.Type != EventGenerated {
+			t.Fatalf("expected generated event, got %+v", ev)
+		}
+	}
+
+	// Simulate GC, which removes the thumbnails one by one.
+	for i, ti := range []ThumbInfo{ti1, ti2} {
+		os.Remove(ti.Path())
+		estl.evicted(ti.Path())
+		if ev := <-events; ev.Type != EventEvicted || ev.ID != ti.String() || ev.Source != srcs[0] {
+			t.Errorf("unexpected evicted event: %+v", ev)
+		}
+		_, err := os.Stat(ti.sourceRecordPath())
+		if last := i == 1; last != os.IsNotExist(err) {
+			t.Errorf("the record of the source should be removed only with the last thumbnail: %v", err)
+		}
+	}
+
+	// The source is unknown if it is not recorded.
+	estl.evicted(ti1.Path())
+	if ev := <-events; ev.Type != EventEvicted || ev.Source != "" {
+		t.Errorf("unexpected evicted event: %+v", ev)
+	}
+}
+
+func TestSubscribeSlow(t *testing.T) {
+	estl, err := New(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	events := estl.Subscribe(context.Background(), 1)
+	estl.events.publish(Event{Type: EventEvicted, ID: "1"})
+	estl.events.publish(Event{Type: EventEvicted, ID: "2"}) // Overflows the buffer
+	estl.events.publish(Event{Type: EventEvicted, ID: "3"}) // Not delivered anymore
+
+	if ev, ok := <-events; !ok || ev.ID != "1" {
+		t.Errorf("expected the first event, got %+v", ev)
+	}
+	if ev, ok := <-events; ok {
+		t.Errorf("channel of the slow subscriber should be closed, got %+v", ev)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -16,28 +17,38 @@ import (
 // garbageCollector manages the disk cache for thumbnails, evicting older files when the limit is exceeded.
 type garbageCollector struct {
 	dir          string
+	mu           sync.Mutex // Protects the limits below, which can be changed by SetLimit
 	limit        int64
-	highLimit    int64 // cache-limit * high-ratio
-	lowLimit     int64 // cache-limit * low-ratio
-	used         int64 // atomic
+	highRatio    float64
+	lowRatio     float64
+	highLimit    int64  // cache-limit * high-ratio
+	lowLimit     int64  // cache-limit * low-ratio
+	forced       int32  // atomic. 1 if Collect is called
+	used         int64  // atomic
+	runs         uint64 // atomic
+	evictions    uint64 // atomic
+	evictedBytes uint64 // atomic
+	onEvict      func(path string)
 	gcSignal     chan struct{}
 	stopCh       chan struct{}
 	stoppedCh    chan struct{}
+	scannedCh    chan struct{} // Closed when the initial scan completes
 	wg           sync.WaitGroup
 	shutdownOnce sync.Once
 }
 
 // newGarbageCollector creates and starts a new garbageCollector.
-func newGarbageCollector(dir string, limit int64, highRatio, lowRatio float64) *garbageCollector {
+// onEvict is called with the path of each evicted file, if not nil.
+func newGarbageCollector(dir string, limit int64, highRatio, lowRatio float64, onEvict func(path string)) *garbageCollector {
 	gc := &garbageCollector{
 		dir:       dir,
-		limit:     limit,
-		highLimit: int64(float64(limit) * highRatio),
-		lowLimit:  int64(float64(limit) * lowRatio),
+		onEvict:   onEvict,
 		gcSignal:  make(chan struct{}, 1),
 		stopCh:    make(chan struct{}),
 		stoppedCh: make(chan struct{}),
+		scannedCh: make(chan struct{}),
 	}
+	gc.setLimit(limit, highRatio, lowRatio)
 	// Asynchronous startup scan
 	gc.wg.Add(1)
 	go gc.run()
@@ -60,6 +71,50 @@ func (gc *garbageCollector) Shutdown(ctx context.Context) error {
 // Track adds delta to the tracked cache usage and triggers garbage collection if the high limit is reached.
 func (gc *garbageCollector) Track(delta int64) {
 	atomic.AddInt64(&gc.used, delta)
+	gc.kick()
+}
+
+// SetLimit changes the cache limit and the watermark ratios, and triggers garbage collection
+// if the new high limit is reached.
+func (gc *garbageCollector) SetLimit(limit int64, highRatio, lowRatio float64) {
+	gc.setLimit(limit, highRatio, lowRatio)
+	gc.kick()
+}
+
+func (gc *garbageCollector) setLimit(limit int64, highRatio, lowRatio float64) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.limit, gc.highRatio, gc.lowRatio = limit, highRatio, lowRatio
+	gc.highLimit = int64(float64(limit) * highRatio)
+	gc.lowLimit = int64(float64(limit) * lowRatio)
+}
+
+// Limit returns the current cache limit and the watermark ratios.
+func (gc *garbageCollector) Limit() (limit int64, highRatio, lowRatio float64) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.limit, gc.highRatio, gc.lowRatio
+}
+
+func (gc *garbageCollector) watermarks() (high, low int64) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.highLimit, gc.lowLimit
+}
+
+// Scanned reports whether the initial scan of the cache directory has completed.
+// Until then, the tracked cache usage is not accurate.
+func (gc *garbageCollector) Scanned() bool {
+	return isClosed(gc.scannedCh)
+}
+
+// Collect triggers garbage collection down to the low limit, even if the high limit is not reached.
+func (gc *garbageCollector) Collect() {
+	atomic.StoreInt32(&gc.forced, 1)
+	gc.kick()
+}
+
+func (gc *garbageCollector) kick() {
 	select {
 	case gc.gcSignal <- struct{}{}:
 	default:
@@ -72,12 +127,16 @@ func (gc *garbageCollector) run() {
 	defer close(gc.stoppedCh)
 
 	gc.initialScan()
+	close(gc.scannedCh)
 	for { // Wait for GC signal or stop channel
 		select {
 		case <-gc.stopCh:
 			return
 		case <-gc.gcSignal:
-			if atomic.LoadInt64(&gc.used) > gc.highLimit {
+			high, _ := gc.watermarks()
+			forced := atomic.SwapInt32(&gc.forced, 0) == 1
+			if forced || atomic.LoadInt64(&gc.used) > high {
+				atomic.AddUint64(&gc.runs, 1)
 				gc.runGC()
 			}
 		}
@@ -104,7 +163,10 @@ func (gc *garbageCollector) initialScan() {
 func (gc *garbageCollector) runGC() {
 	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	for atomic.LoadInt64(&gc.used) > gc.lowLimit {
+	for {
+		if _, low := gc.watermarks(); atomic.LoadInt64(&gc.used) <= low {
+			return
+		}
 		select {
 		case <-gc.stopCh:
 			return
@@ -186,9 +248,12 @@ func (gc *garbageCollector) evictOneBatch(rng *rand.Rand) int64 {
 		return 0
 	}
 
-	var oldest fs.DirEntry
-	var oldestInfo fs.FileInfo
+	// Records of the sources are never accessed, so they would always be the oldest.
+	// They are removed together with the thumbnails, and evicted here only if left alone.
+	var oldest, oldestRecord fs.DirEntry
+	var oldestInfo, oldestRecordInfo fs.FileInfo
 	oldestTime := time.UnixMilli(math.MaxInt64) // Initialize with far future time
+	oldestRecordTime := oldestTime
 
 	for _, de := range entries {
 		if de.Type().IsRegular() {
@@ -197,7 +262,13 @@ func (gc *garbageCollector) evictOneBatch(rng *rand.Rand) int64 {
 				continue // file seems to have been removed or renamed
 			}
 			t := GetAtime(fi)
-			if t.Before(oldestTime) {
+			if strings.HasSuffix(de.Name(), sourceRecordExt) {
+				if t.Before(oldestRecordTime) {
+					oldestRecord = de
+					oldestRecordTime = t
+					oldestRecordInfo = fi
+				}
+			} else if t.Before(oldestTime) {
 				oldest = de
 				oldestTime = t
 				oldestInfo = fi
@@ -205,6 +276,9 @@ func (gc *garbageCollector) evictOneBatch(rng *rand.Rand) int64 {
 		}
 	}
 
+	if oldest == nil {
+		oldest, oldestInfo = oldestRecord, oldestRecordInfo
+	}
 	if oldest == nil {
 		return 0
 	}
@@ -217,6 +291,11 @@ func (gc *garbageCollector) evictOneBatch(rng *rand.Rand) int64 {
 		return 0
 	}
 	atomic.AddInt64(&gc.used, -size)
+	atomic.AddUint64(&gc.evictions, 1)
+	atomic.AddUint64(&gc.evictedBytes, uint64(size))
+	if gc.onEvict != nil {
+		gc.onEvict(path)
+	}
 
 	if len(entries) == 1 {
 		err = os.Remove(n2Path)
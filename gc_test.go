@@ -0,0 +1,40 @@
+package estelle
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEvictSourceRecordLast(t *testing.T) {
+	dir := t.TempDir()
+	shard := filepath.Join(dir, "ab", "cd")
+	if err := os.MkdirAll(shard, 0755); err != nil {
+		t.Fatal(err)
+	}
+	record := filepath.Join(shard, "abcd"+sourceRecordExt)
+	thumb := filepath.Join(shard, "abcd-100x100-crop.jpg")
+	old := time.Now().Add(-time.Hour)
+	for i, path := range []string{record, thumb} {
+		if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		// The record is older than the thumbnail, but must not be evicted first.
+		at := old.Add(time.Duration(i) * time.Minute)
+		os.Chtimes(path, at, at)
+	}
+
+	var evicted []string
+	gc := &garbageCollector{dir: dir, onEvict: func(path string) { evicted = append(evicted, path) }}
+	rng := rand.New(rand.NewSource(1))
+	for range 2 {
+		if gc.evictOneBatch(rng) == 0 {
+			t.Fatal("nothing was evicted")
+		}
+	}
+	if len(evicted) != 2 || evicted[0] != thumb || evicted[1] != record {
+		t.Errorf("expected %s to be evicted before %s, got %v", thumb, record, evicted)
+	}
+}
@@ -0,0 +1,30 @@
+package estelle
+
+import (
+	"context"
+)
+
+// Generator is the interface implemented by thumbnail generation backends.
+//
+// Generate reads the image at source, resizes it to size according to mode,
+// encodes it in format and writes the result to output.
+// output is a temporary path in the cache directory; Estelle renames it to the final
+// thumbnail path after Generate returns successfully, so implementations must not
+// write anywhere else. Implementations should abort when ctx is done.
+type Generator interface {
+	Generate(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error
+}
+
+// GeneratorFunc is an adapter to allow the use of ordinary functions as Generator.
+type GeneratorFunc func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error
+
+// Generate calls f(ctx, source, size, mode, format, output).
+func (f GeneratorFunc) Generate(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+	return f(ctx, source, size, mode, format, output)
+}
+
+// Checker is implemented by Generators which can check whether they are able to work,
+// e.g. whether the external command is installed. It is used by Estelle.CheckHealth.
+type Checker interface {
+	Check() error
+}
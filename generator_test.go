@@ -0,0 +1,101 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestWithGenerator(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcFile := filepath.Join(tmpDir, "src.jpg")
+	if err := os.WriteFile(srcFile, []byte("dummy image content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int32
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		atomic.AddInt32(&calls, 1)
+		if source != srcFile {
+			t.Errorf("unexpected source: %q", source)
+		}
+		if size != SizeFromUint(100, 50) || mode != ModeShrink || format != FMT_PNG {
+			t.Errorf("unexpected parameters: %s %s %s", size, mode, format)
+		}
+		return os.WriteFile(output, []byte("thumbnail"), 0644)
+	})
+
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	ti, err := estl.NewThumbInfo(srcFile, SizeFromUint(100, 50), ModeShrink, FMT_PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if err := res.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !ti.Exists() {
+		t.Fatal("thumbnail should exist")
+	}
+
+	// Cache hit must not call the generator again.
+	res, err = estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("generator called %d times, want 1", n)
+	}
+}
+
+func TestWithGeneratorError(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcFile := filepath.Join(tmpDir, "src.jpg")
+	if err := os.WriteFile(srcFile, []byte("dummy image content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	errGen := errors.New("generation failed")
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		os.WriteFile(output, []byte("partial"), 0644)
+		return errGen
+	})
+
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	ti, err := estl.NewThumbInfo(srcFile, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if !errors.Is(res.Err(), errGen) {
+		t.Errorf("expected %v, got %v", errGen, res.Err())
+	}
+	if ti.Exists() {
+		t.Error("thumbnail should not exist after failure")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(ti.Path()), "incomplete_"+ti.String())); !os.IsNotExist(err) {
+		t.Errorf("temporary file should be removed: %v", err)
+	}
+}
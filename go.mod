@@ -3,8 +3,8 @@ module github.com/Maki-Daisuke/estelle/v2
 go 1.25.6
 
 require (
-	github.com/Maki-Daisuke/go-filiq v0.4.0
 	github.com/caarlos0/env/v11 v11.3.1
+	github.com/orcaman/concurrent-map/v2 v2.0.1
+	golang.org/x/image v0.40.0
+	golang.org/x/sys v0.44.0
 )
-
-require github.com/orcaman/concurrent-map/v2 v2.0.1
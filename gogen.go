@@ -0,0 +1,213 @@
+package estelle
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	"image/png"
+	"math"
+	"os"
+	"os/exec"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// GoGenerator is a Generator implemented purely in Go, without any external command.
+// It decodes JPEG, PNG, GIF and WebP, and encodes JPEG and PNG.
+// WebP output is not supported, because there is no WebP encoder in pure Go.
+//
+// This is intended as a fallback for systems where `vipsthumbnail` is not available.
+// It is considerably slower than VipsGenerator and uses more memory, since it decodes
+// the whole source image at full resolution.
+type GoGenerator struct {
+	// CenterCrop makes ModeCrop cut out the center of the image.
+	// By default, the region with the highest entropy (i.e. the most detailed region)
+	// is kept instead, which approximates `vipsthumbnail --smartcrop`.
+	CenterCrop bool
+	// JPEGQuality is the quality of JPEG output, ranging from 1 to 100.
+	// If zero, 75 is used, which is the same as vipsthumbnail.
+	JPEGQuality int
+}
+
+// DefaultGenerator returns VipsGenerator if `vipsthumbnail` command is found in $PATH,
+// otherwise GoGenerator.
+func DefaultGenerator() Generator {
+	if _, err := exec.LookPath("vipsthumbnail"); err == nil {
+		return VipsGenerator{}
+	}
+	return GoGenerator{}
+}
+
+// Generate decodes source, resizes it and writes the encoded thumbnail to output.
+func (g GoGenerator) Generate(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+	return g.GenerateMulti(ctx, source, []Output{{Variant{size, mode, format}, output}})[0]
+}
+
+// GenerateMulti decodes source only once, and writes the thumbnails for all outputs.
+func (g GoGenerator) GenerateMulti(ctx context.Context, source string, outputs []Output) []error {
+	errs := make([]error, len(outputs))
+	valid := 0
+	for i, o := range outputs {
+		if o.Size.Width == 0 || o.Size.Height == 0 {
+			errs[i] = fmt.Errorf("invalid thumbnail size: %s", o.Size)
+		} else if o.Format != FMT_JPG && o.Format != FMT_PNG {
+			errs[i] = fmt.Errorf("%w: GoGenerator cannot write %s", ErrUnsupportedFormat, o.Format)
+		} else {
+			valid++
+		}
+	}
+	if valid == 0 {
+		return errs // Don't decode in vain
+	}
+	failAll := func(err error) []error {
+		for i := range errs {
+			if errs[i] == nil {
+				errs[i] = err
+			}
+		}
+		return errs
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return failAll(err)
+	}
+	src, _, err := image.Decode(in)
+	in.Close()
+	if err != nil {
+		return failAll(&decodeError{fmt.Errorf("failed to decode %s: %w", source, err)})
+	}
+	if err := ctx.Err(); err != nil {
+		return failAll(err)
+	}
+
+	for i, o := range outputs {
+		if errs[i] == nil {
+			errs[i] = g.render(ctx, src, o)
+		}
+	}
+	return errs
+}
+
+// render resizes src and writes the thumbnail to o.Path.
+func (g GoGenerator) render(ctx context.Context, src image.Image, o Output) error {
+	var dst image.Image
+	switch o.Mode {
+	case ModeCrop:
+		dst = g.crop(src, int(o.Size.Width), int(o.Size.Height))
+	case ModeShrink:
+		dst = shrink(src, int(o.Size.Width), int(o.Size.Height))
+	case ModeStretch:
+		dst = scale(src, int(o.Size.Width), int(o.Size.Height))
+	default:
+		return fmt.Errorf("unknown mode: %d", o.Mode)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	out, err := os.Create(o.Path)
+	if err != nil {
+		return err
+	}
+	switch o.Format {
+	case FMT_JPG:
+		quality := g.JPEGQuality
+		if quality == 0 {
+			quality = 75
+		}
+		err = jpeg.Encode(out, dst, &jpeg.Options{Quality: quality})
+	case FMT_PNG:
+		err = png.Encode(out, dst)
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// scale resizes src to exactly w x h.
+func scale(src image.Image, w, h int) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// shrink resizes src to fit within w x h, keeping aspect ratio.
+func shrink(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	r := math.Min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	return scale(src, max(1, int(math.Round(float64(b.Dx())*r))), max(1, int(math.Round(float64(b.Dy())*r))))
+}
+
+// crop resizes src to cover w x h, keeping aspect ratio, and then cuts out w x h region.
+func (g GoGenerator) crop(src image.Image, w, h int) *image.RGBA {
+	b := src.Bounds()
+	r := math.Max(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	scaled := scale(src, max(w, int(math.Round(float64(b.Dx())*r))), max(h, int(math.Round(float64(b.Dy())*r))))
+
+	var rect image.Rectangle
+	if g.CenterCrop {
+		sb := scaled.Bounds()
+		x := (sb.Dx() - w) / 2
+		y := (sb.Dy() - h) / 2
+		rect = image.Rect(x, y, x+w, y+h)
+	} else {
+		rect = entropyCrop(scaled, w, h)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), scaled, rect.Min, draw.Src)
+	return dst
+}
+
+// entropyCrop finds w x h region of img to be kept.
+// Like `vips_smartcrop` with "entropy" interesting, it repeatedly trims a slice from
+// either edge, dropping the one with lower entropy, until the region fits.
+func entropyCrop(img *image.RGBA, w, h int) image.Rectangle {
+	r := img.Bounds()
+	for r.Dx() > w {
+		step := min(r.Dx()-w, max(1, w/8))
+		left := image.Rect(r.Min.X, r.Min.Y, r.Min.X+step, r.Max.Y)
+		right := image.Rect(r.Max.X-step, r.Min.Y, r.Max.X, r.Max.Y)
+		if entropy(img, left) < entropy(img, right) {
+			r.Min.X += step
+		} else {
+			r.Max.X -= step
+		}
+	}
+	for r.Dy() > h {
+		step := min(r.Dy()-h, max(1, h/8))
+		top := image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+step)
+		bottom := image.Rect(r.Min.X, r.Max.Y-step, r.Max.X, r.Max.Y)
+		if entropy(img, top) < entropy(img, bottom) {
+			r.Min.Y += step
+		} else {
+			r.Max.Y -= step
+		}
+	}
+	return r
+}
+
+// entropy calculates Shannon entropy of luminance histogram within rect.
+func entropy(img *image.RGBA, rect image.Rectangle) float64 {
+	var hist [256]int
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			hist[color.GrayModel.Convert(img.RGBAAt(x, y)).(color.Gray).Y]++
+		}
+	}
+	total := float64(rect.Dx() * rect.Dy())
+	var e float64
+	for _, n := range hist {
+		if n > 0 {
+			p := float64(n) / total
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
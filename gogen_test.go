@@ -0,0 +1,101 @@
+package estelle
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGoGenerator(t *testing.T) {
+	const fileName = "tests/IMG_20141207_201549.jpg"
+	tmpDir := t.TempDir()
+
+	tests := []struct {
+		mode   Mode
+		format Format
+		size   Size
+		want   image.Point
+	}{
+		{ModeCrop, FMT_JPG, SizeFromUint(100, 100), image.Pt(100, 100)},
+		{ModeCrop, FMT_PNG, SizeFromUint(120, 40), image.Pt(120, 40)},
+		{ModeStretch, FMT_JPG, SizeFromUint(100, 30), image.Pt(100, 30)},
+		{ModeShrink, FMT_PNG, SizeFromUint(100, 100), image.Pt(100, 75)}, // Source is 4:3 landscape
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode.String()+"-"+tt.format.String(), func(t *testing.T) {
+			out := filepath.Join(tmpDir, "out-"+tt.mode.String()+"."+tt.format.String())
+			err := GoGenerator{}.Generate(context.Background(), fileName, tt.size, tt.mode, tt.format, out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Open(out)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			cfg, format, err := image.DecodeConfig(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if FormatFromString(format) != tt.format {
+				t.Errorf("format: expected %s, but got %s", tt.format, format)
+			}
+			if got := image.Pt(cfg.Width, cfg.Height); got != tt.want {
+				t.Errorf("size: expected %v, but got %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("webp is not supported", func(t *testing.T) {
+		out := filepath.Join(tmpDir, "out.webp")
+		err := GoGenerator{}.Generate(context.Background(), fileName, SizeFromUint(100, 100), ModeCrop, FMT_WEBP, out)
+		if err == nil {
+			t.Error("expected error for webp output")
+		}
+	})
+
+	t.Run("not an image", func(t *testing.T) {
+		src := filepath.Join(tmpDir, "invalid.jpg")
+		os.WriteFile(src, []byte("not an image"), 0644)
+		err := GoGenerator{}.Generate(context.Background(), src, SizeFromUint(100, 100), ModeCrop, FMT_JPG, filepath.Join(tmpDir, "invalid-out.jpg"))
+		if err == nil {
+			t.Error("expected error for invalid image")
+		}
+	})
+}
+
+func TestEntropyCrop(t *testing.T) {
+	// Left half is flat, right half is noisy. The crop should keep the right half.
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.RGBA{128, 128, 128, 255}
+			if x >= 100 {
+				v := uint8((x*31 + y*17) ^ (x * y))
+				c = color.RGBA{v, v, v, 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+	rect := entropyCrop(src, 100, 100)
+	if rect.Dx() != 100 || rect.Dy() != 100 {
+		t.Fatalf("unexpected crop size: %v", rect)
+	}
+	if rect.Min.X < 90 {
+		t.Errorf("expected the noisy right half to be kept, but got %v", rect)
+	}
+
+	tmpDir := t.TempDir()
+	srcFile := filepath.Join(tmpDir, "src.png")
+	f, _ := os.Create(srcFile)
+	png.Encode(f, src)
+	f.Close()
+	out := filepath.Join(tmpDir, "center.png")
+	if err := (GoGenerator{CenterCrop: true}).Generate(context.Background(), srcFile, SizeFromUint(50, 50), ModeCrop, FMT_PNG, out); err != nil {
+		t.Fatal(err)
+	}
+}
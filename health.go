@@ -0,0 +1,33 @@
+package estelle
+
+import "fmt"
+
+// HealthCheck is the result of a check performed by Estelle.CheckHealth.
+type HealthCheck struct {
+	Name string // "generator", "cache_dir", "gc" or "queue"
+	Err  error  // nil if the check passed
+}
+
+// CheckHealth checks whether Estelle is ready to generate thumbnails, that is:
+//   - generator: the Generator is able to work, if it implements Checker
+//   - cache_dir: the cache directory is writable
+//   - gc: the initial scan of the cache directory has completed
+//   - queue: Estelle is not closed and the queue is not full
+//
+// It returns the results of all the checks in the above order.
+func (estl *Estelle) CheckHealth() []HealthCheck {
+	checks := []HealthCheck{{Name: "generator"}, {Name: "cache_dir"}, {Name: "gc"}, {Name: "queue"}}
+	if c, ok := estl.gen.(Checker); ok {
+		checks[0].Err = c.Check()
+	}
+	checks[1].Err = estl.dir.Check()
+	if !estl.gc.Scanned() {
+		checks[2].Err = fmt.Errorf("initial scan of the cache directory is in progress")
+	}
+	if estl.pendingTasks.Load() == nil {
+		checks[3].Err = ErrEstelleClosed
+	} else if queued, _ := estl.runner.Load(); estl.runner.maxBuffer > 0 && queued >= estl.runner.maxBuffer {
+		checks[3].Err = ErrEstelleQueueFull
+	}
+	return checks
+}
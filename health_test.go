@@ -0,0 +1,83 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCheckHealth(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg")
+	gen := newBlockingGenerator()
+	estl, err := New(cacheDir, WithGenerator(gen), WithWorkers(1), WithBufferSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	failed := func() map[string]error {
+		errs := map[string]error{}
+		for _, c := range estl.CheckHealth() {
+			if c.Err != nil {
+				errs[c.Name] = c.Err
+			}
+		}
+		return errs
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(failed()) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected healthy, got %v", failed())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Saturated queue
+	var results []*Result
+	for i, src := range srcs {
+		ti, _ := estl.NewThumbInfo(src, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+		res, err := estl.Enqueue(ti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+		if i == 0 {
+			<-gen.started
+		}
+	}
+	if errs := failed(); len(errs) != 1 || errs["queue"] != ErrEstelleQueueFull {
+		t.Errorf("expected queue to be full, got %v", errs)
+	}
+	close(gen.unblock)
+	for _, res := range results {
+		<-res.Done()
+	}
+
+	// Cache dir is gone
+	os.RemoveAll(cacheDir)
+	if errs := failed(); errs["cache_dir"] == nil {
+		t.Errorf("expected cache_dir to fail, got %v", errs)
+	}
+	estl.Shutdown(context.Background())
+	if errs := failed(); errs["queue"] != ErrEstelleClosed {
+		t.Errorf("expected closed, got %v", errs)
+	}
+}
+
+func TestVipsGeneratorCheck(t *testing.T) {
+	if err := (VipsGenerator{Command: filepath.Join(t.TempDir(), "no-such-vipsthumbnail")}).Check(); !errors.Is(err, ErrGeneratorMissing) {
+		t.Errorf("expected ErrGeneratorMissing, got %v", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (VipsGenerator{Command: exe}).Check(); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+}
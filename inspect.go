@@ -0,0 +1,62 @@
+package estelle
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"os"
+
+	_ "golang.org/x/image/tiff" // register TIFF decoder
+)
+
+// SourceTooLargeError is returned by Enqueue when the source image exceeds the limits
+// set by WithMaxFileSize or WithMaxPixels. This protects the generator from
+// decompression bombs, i.e. small files which expand to enormous images.
+type SourceTooLargeError struct {
+	Source      string
+	FileSize    int64 // Size of the source file in bytes
+	MaxFileSize int64 // 0 if the file size is within the limit
+	Width       int   // Dimensions of the source image; 0 if they are not inspected
+	Height      int
+	MaxPixels   int64 // 0 if the pixel count is within the limit
+}
+
+func (e *SourceTooLargeError) Error() string {
+	if e.MaxFileSize > 0 {
+		return fmt.Sprintf("source file is too large: %s (%d bytes > %d bytes)", e.Source, e.FileSize, e.MaxFileSize)
+	}
+	return fmt.Sprintf("source image is too large: %s (%dx%d > %d pixels)", e.Source, e.Width, e.Height, e.MaxPixels)
+}
+
+// checkSource inspects the source file against the limits before generating its thumbnail.
+// Only the header is read to learn the dimensions, for JPEG, PNG, GIF, WebP and TIFF.
+// Sources in other formats, or with broken headers, are not rejected here; that is left to the Generator.
+// 0 means no limit for each of maxFileSize and maxPixels.
+func checkSource(path string, maxFileSize, maxPixels int64) error {
+	if maxFileSize <= 0 && maxPixels <= 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if maxFileSize > 0 && st.Size() > maxFileSize {
+		return &SourceTooLargeError{Source: path, FileSize: st.Size(), MaxFileSize: maxFileSize}
+	}
+	if maxPixels <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(bufio.NewReader(f))
+	if err != nil {
+		return nil // Unknown format or broken header
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > maxPixels {
+		return &SourceTooLargeError{Source: path, FileSize: st.Size(), Width: cfg.Width, Height: cfg.Height, MaxPixels: maxPixels}
+	}
+	return nil
+}
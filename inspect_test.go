@@ -0,0 +1,76 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	pngFile := filepath.Join(tmpDir, "100x50.png")
+	f, err := os.Create(pngFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewGray(image.Rect(0, 0, 100, 50)))
+	f.Close()
+	st, _ := os.Stat(pngFile)
+
+	unknownFile := filepath.Join(tmpDir, "unknown.jpg")
+	os.WriteFile(unknownFile, []byte("not an image"), 0644)
+
+	tests := []struct {
+		name        string
+		path        string
+		maxFileSize int64
+		maxPixels   int64
+		tooLarge    bool
+	}{
+		{"no limits", pngFile, 0, 0, false},
+		{"within limits", pngFile, st.Size(), 5000, false},
+		{"too many pixels", pngFile, 0, 4999, true},
+		{"too large file", pngFile, st.Size() - 1, 0, true},
+		{"unknown format is left to the generator", unknownFile, 0, 1, false},
+		{"JPEG header", "tests/IMG_20141207_201549.jpg", 0, 1000 * 1000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSource(tt.path, tt.maxFileSize, tt.maxPixels)
+			var tle *SourceTooLargeError
+			if got := errors.As(err, &tle); got != tt.tooLarge {
+				t.Errorf("expected tooLarge=%v, but got error: %v", tt.tooLarge, err)
+			}
+		})
+	}
+}
+
+func TestEnqueueRejectsTooLargeSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		t.Error("generator should not be called")
+		return nil
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithMaxPixels(1000*1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	ti, err := estl.NewThumbInfo("tests/IMG_20141207_201549.jpg", SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := estl.Enqueue(ti)
+	var tle *SourceTooLargeError
+	if !errors.As(err, &tle) {
+		t.Fatalf("expected SourceTooLargeError, but got (%v, %v)", res, err)
+	}
+	if tle.Width == 0 || tle.Height == 0 {
+		t.Errorf("dimensions should be reported: %+v", tle)
+	}
+}
@@ -1,6 +1,7 @@
 package estelle
 
 import (
+	"context"
 	"os"
 	"testing"
 	"time"
@@ -22,7 +23,7 @@ func TestLazyTouch(t *testing.T) {
 	}
 
 	// Create thumbnail
-	err = thumbInfo.make()
+	err = thumbInfo.make(context.Background(), DefaultGenerator())
 	if err != nil {
 		t.Fatalf("Failed to make thumbnail: %v", err)
 	}
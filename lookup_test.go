@@ -0,0 +1,76 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLookup(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithWorkers(1), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	// Miss without generation
+	if _, pending, err := estl.Lookup(ti); err != ErrNotCached || pending {
+		t.Fatalf("expected (pending=false, ErrNotCached), got (%v, %v)", pending, err)
+	}
+	select {
+	case <-gen.started:
+		t.Fatal("Lookup should not trigger generation")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Pending
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+	if _, pending, err := estl.Lookup(ti); err != ErrNotCached || !pending {
+		t.Fatalf("expected (pending=true, ErrNotCached), got (%v, %v)", pending, err)
+	}
+
+	// Hit
+	close(gen.unblock)
+	<-res.Done()
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	meta, pending, err := estl.Lookup(ti)
+	if err != nil || pending || !meta.CacheHit {
+		t.Fatalf("expected hit, got (%+v, %v, %v)", meta, pending, err)
+	}
+}
+
+func TestLookupCachedFailure(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "broken.jpg")
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		return errors.New("broken")
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+
+	var cfe *CachedFailureError
+	if _, _, err := estl.Lookup(ti); !errors.As(err, &cfe) {
+		t.Errorf("expected CachedFailureError, got %v", err)
+	}
+}
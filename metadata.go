@@ -0,0 +1,46 @@
+package estelle
+
+import (
+	"image"
+	_ "image/jpeg" // register JPEG decoder
+	_ "image/png"  // register PNG decoder
+	"os"
+	"time"
+
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// Metadata describes a thumbnail file.
+type Metadata struct {
+	Width    int    // Actual width of the thumbnail, which may be smaller than requested in ModeShrink
+	Height   int    // Actual height of the thumbnail. Both are zero if the header is unreadable.
+	Bytes    int64  // File size of the thumbnail
+	Format   Format // File format of the thumbnail
+	CacheHit bool   // True if the thumbnail already existed and was not generated by this task
+
+	QueueTime    time.Duration // How long the task waited in the queue. Zero for cache hits.
+	GenerateTime time.Duration // How long the generation took. Zero for cache hits.
+}
+
+// Metadata returns the metadata of the cached thumbnail.
+// The dimensions are read from the image header, so the thumbnail is not decoded.
+// It returns an error if the thumbnail does not exist.
+func (ti ThumbInfo) Metadata() (Metadata, error) {
+	f, err := os.Open(ti.path)
+	if err != nil {
+		return Metadata{}, err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return Metadata{}, err
+	}
+	// A Generator may write a format unknown to Go. That's not an error of the thumbnail.
+	cfg, _, _ := image.DecodeConfig(f)
+	return Metadata{
+		Width:  cfg.Width,
+		Height: cfg.Height,
+		Bytes:  st.Size(),
+		Format: ti.format,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package estelle
+
+import (
+	"context"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResultMetadata(t *testing.T) {
+	tmpDir := t.TempDir()
+	src := filepath.Join(tmpDir, "200x100.png")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(f, image.NewGray(image.Rect(0, 0, 200, 100)))
+	f.Close()
+
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(GoGenerator{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, err := estl.NewThumbInfo(src, SizeFromUint(50, 50), ModeShrink, FMT_PNG)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if err := res.Err(); err != nil {
+		t.Fatal(err)
+	}
+	meta := res.Metadata()
+	if meta.Width != 50 || meta.Height != 25 {
+		t.Errorf("expected 50x25, got %dx%d", meta.Width, meta.Height)
+	}
+	st, _ := os.Stat(ti.Path())
+	if meta.Bytes != st.Size() {
+		t.Errorf("expected %d bytes, got %d", st.Size(), meta.Bytes)
+	}
+	if meta.Format != FMT_PNG || meta.CacheHit || meta.GenerateTime <= 0 {
+		t.Errorf("unexpected metadata of generated thumbnail: %+v", meta)
+	}
+
+	// Cache hit reports the same dimensions without timings.
+	res, err = estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	hit := res.Metadata()
+	if !hit.CacheHit || hit.Width != 50 || hit.Height != 25 || hit.Bytes != meta.Bytes || hit.GenerateTime != 0 {
+		t.Errorf("unexpected metadata of cache hit: %+v", hit)
+	}
+}
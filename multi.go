@@ -0,0 +1,129 @@
+package estelle
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCoalesce is the max number of thumbnails generated at once by MultiGenerator.
+const maxCoalesce = 8
+
+// Variant is a set of parameters of a thumbnail.
+type Variant struct {
+	Size   Size
+	Mode   Mode
+	Format Format
+}
+
+// Output is a thumbnail to be generated by MultiGenerator.
+type Output struct {
+	Variant
+	// Path is a temporary path to write the thumbnail, like output of Generator.Generate.
+	Path string
+}
+
+// MultiGenerator is implemented by Generators which can generate multiple thumbnails of the same
+// source at once, decoding the source only once.
+// Estelle uses it to generate the queued thumbnails of the same source together.
+//
+// GenerateMulti returns the error for each of outputs in the same order, where nil means success.
+type MultiGenerator interface {
+	Generator
+	GenerateMulti(ctx context.Context, source string, outputs []Output) []error
+}
+
+// EnqueueSet enqueues the thumbnails of source in all the variants at once, so that they are
+// generated from a single decode of the source if the Generator implements MultiGenerator.
+// It returns the Results in the same order as variants. If some of the variants cannot be
+// enqueued, e.g. the previous generation failed, their Results are already done with the error.
+// An error is returned only if source cannot be read or Estelle is already closed.
+func (estl *Estelle) EnqueueSet(source string, variants []Variant, opts ...EnqueueOption) ([]*Result, error) {
+	var cfg enqueueConfig
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	// Unlike Enqueue, queue full and client quota exceeded count the set as a single job.
+	j := &job{priority: cfg.priority, client: cfg.client}
+	results := make([]*Result, len(variants))
+	var tasks []*Result
+	for i, v := range variants {
+		ti, err := estl.NewThumbInfo(source, v.Size, v.Mode, v.Format)
+		if err != nil {
+			return nil, err // It's the first one, since all the variants share the source.
+		}
+		res, isNew, err := estl.prepare(context.Background(), ti, j)
+		if err == ErrEstelleClosed {
+			if len(tasks) > 0 {
+				estl.submit(j, tasks...) // Fails and completes the prepared tasks with the error
+			}
+			return nil, err
+		}
+		if err != nil {
+			res = failedResult(err)
+		}
+		if isNew {
+			tasks = append(tasks, res)
+		}
+		results[i] = res
+	}
+	if len(tasks) > 0 {
+		estl.submit(j, tasks...) // The error is set to the Results
+	}
+	return results, nil
+}
+
+// coalesce adds the queued tasks for the same source, client and priority as tasks,
+// taking them out of the queue.
+func (estl *Estelle) coalesce(tasks []*Result) []*Result {
+	pending := estl.pendingTasks.Load()
+	if pending == nil || len(tasks) >= maxCoalesce {
+		return tasks
+	}
+	hash := tasks[0].ti.hash()
+	var jobs []*job
+	seen := map[*job]bool{tasks[0].job: true}
+	pending.IterCb(func(key string, res *Result) {
+		if strings.HasPrefix(key, hash+"-") && !seen[res.job] {
+			seen[res.job] = true
+			jobs = append(jobs, res.job)
+		}
+	})
+	for _, j := range jobs {
+		if len(tasks) >= maxCoalesce {
+			break
+		}
+		// j.tasks must not be read before Take, since j may not be submitted yet.
+		if estl.runner.Take(j, tasks[0].job) {
+			tasks = append(tasks, j.tasks...)
+		}
+	}
+	return tasks
+}
+
+// mergeContexts returns a context which is cancelled when all of ctxs are cancelled.
+func mergeContexts(ctxs []context.Context) (context.Context, context.CancelFunc) {
+	if len(ctxs) == 1 {
+		return context.WithCancel(ctxs[0])
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	remaining := int32(len(ctxs))
+	stops := make([]func() bool, len(ctxs))
+	for i, c := range ctxs {
+		stops[i] = context.AfterFunc(c, func() {
+			if atomic.AddInt32(&remaining, -1) == 0 {
+				cancel(context.Cause(c))
+			}
+		})
+	}
+	var once sync.Once
+	return ctx, func() {
+		once.Do(func() {
+			for _, stop := range stops {
+				stop()
+			}
+			cancel(context.Canceled)
+		})
+	}
+}
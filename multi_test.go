@@ -0,0 +1,244 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"image"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// multiGenerator is a blockingGenerator which also implements MultiGenerator, recording the calls.
+type multiGenerator struct {
+	*blockingGenerator
+	mu    sync.Mutex
+	calls [][]Output
+}
+
+func (g *multiGenerator) GenerateMulti(ctx context.Context, source string, outputs []Output) []error {
+	g.mu.Lock()
+	g.calls = append(g.calls, append([]Output(nil), outputs...))
+	g.mu.Unlock()
+	errs := make([]error, len(outputs))
+	for i, o := range outputs {
+		errs[i] = g.Generate(ctx, source, o.Size, o.Mode, o.Format, o.Path)
+	}
+	return errs
+}
+
+func (g *multiGenerator) Calls() [][]Output {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls
+}
+
+func TestEnqueueSet(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	gen := &multiGenerator{blockingGenerator: newBlockingGenerator()}
+	close(gen.unblock)
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	variants := []Variant{
+		{SizeFromUint(100, 100), ModeCrop, FMT_JPG},
+		{SizeFromUint(200, 200), ModeShrink, FMT_PNG},
+		{SizeFromUint(100, 100), ModeCrop, FMT_JPG}, // Duplicate
+	}
+	results, err := estl.EnqueueSet(srcs[0], variants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(variants) {
+		t.Fatalf("expected %d results, got %d", len(variants), len(results))
+	}
+	if results[0] != results[2] {
+		t.Error("duplicate variants should share the Result")
+	}
+	for i, res := range results {
+		<-res.Done()
+		if err := res.Err(); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		ti, _ := estl.NewThumbInfo(srcs[0], variants[i].Size, variants[i].Mode, variants[i].Format)
+		if _, err := os.Stat(ti.Path()); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+	if calls := gen.Calls(); len(calls) != 1 || len(calls[0]) != 2 {
+		t.Fatalf("expected a single call with 2 outputs, got %v", calls)
+	}
+
+	// All cached now
+	results, err = estl.EnqueueSet(srcs[0], variants)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		<-res.Done()
+		if !res.Metadata().CacheHit {
+			t.Errorf("#%d: expected cache hit", i)
+		}
+	}
+	if calls := gen.Calls(); len(calls) != 1 {
+		t.Errorf("expected no more calls, got %d", len(calls))
+	}
+}
+
+func TestCoalesceQueuedVariants(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg")
+	gen := &multiGenerator{blockingGenerator: newBlockingGenerator()}
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithWorkers(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	// Occupy the only worker
+	tiB, _ := estl.NewThumbInfo(srcs[1], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	resB, err := estl.Enqueue(tiB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+
+	var results []*Result
+	for _, w := range []uint{50, 100, 150} {
+		ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(w, w), ModeCrop, FMT_JPG)
+		res, err := estl.Enqueue(ti)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	close(gen.unblock)
+	<-resB.Done()
+	for i, res := range results {
+		<-res.Done()
+		if err := res.Err(); err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+	}
+	calls := gen.Calls()
+	if len(calls) != 1 || len(calls[0]) != 3 {
+		t.Fatalf("expected the queued variants to be generated at once, got %v", calls)
+	}
+}
+
+func TestGoGeneratorMulti(t *testing.T) {
+	const fileName = "tests/IMG_20141207_201549.jpg"
+	tmpDir := t.TempDir()
+	outputs := []Output{
+		{Variant{SizeFromUint(100, 100), ModeCrop, FMT_JPG}, filepath.Join(tmpDir, "crop.jpg")},
+		{Variant{SizeFromUint(100, 100), ModeShrink, FMT_PNG}, filepath.Join(tmpDir, "shrink.png")},
+		{Variant{SizeFromUint(100, 100), ModeCrop, FMT_WEBP}, filepath.Join(tmpDir, "crop.webp")},
+	}
+	errs := GoGenerator{}.GenerateMulti(context.Background(), fileName, outputs)
+	if len(errs) != len(outputs) {
+		t.Fatalf("expected %d errors, got %d", len(outputs), len(errs))
+	}
+	for i, want := range []image.Point{image.Pt(100, 100), image.Pt(100, 75)} {
+		if errs[i] != nil {
+			t.Fatalf("#%d: %v", i, errs[i])
+		}
+		f, err := os.Open(outputs[i].Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cfg, _, err := image.DecodeConfig(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := image.Pt(cfg.Width, cfg.Height); got != want {
+			t.Errorf("#%d: expected %v, got %v", i, want, got)
+		}
+	}
+	if !errors.Is(errs[2], ErrUnsupportedFormat) {
+		t.Errorf("expected ErrUnsupportedFormat for webp, got %v", errs[2])
+	}
+
+	// Decode failure is reported for all the outputs
+	errs = GoGenerator{}.GenerateMulti(context.Background(), filepath.Join(tmpDir, "missing.jpg"), outputs[:2])
+	for i, err := range errs {
+		if err == nil {
+			t.Errorf("#%d: expected error for missing source", i)
+		}
+	}
+}
+
+func TestVipsIntermediateSize(t *testing.T) {
+	const fileName = "tests/IMG_20141207_201549.jpg" // 3264x2448
+	small := []Output{
+		{Variant: Variant{SizeFromUint(100, 100), ModeCrop, FMT_JPG}},
+		{Variant: Variant{SizeFromUint(200, 200), ModeShrink, FMT_JPG}},
+	}
+	size, ok := VipsGenerator{}.intermediateSize(fileName, small)
+	if !ok || size < 202 || size > 203 {
+		t.Errorf("expected about 202, got (%d, %v)", size, ok)
+	}
+	if _, ok := (VipsGenerator{}).intermediateSize(fileName, small[:1]); ok {
+		t.Error("intermediate is unnecessary for a single output")
+	}
+	large := append(small, Output{Variant: Variant{SizeFromUint(2000, 2000), ModeCrop, FMT_JPG}})
+	if _, ok := (VipsGenerator{}).intermediateSize(fileName, large); ok {
+		t.Error("intermediate is not worthwhile for a large output")
+	}
+	if _, ok := (VipsGenerator{}).intermediateSize("missing.jpg", small); ok {
+		t.Error("intermediate needs the source size")
+	}
+}
+
+func TestCoalescedTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	gen := &multiGenerator{blockingGenerator: newBlockingGenerator()}
+	close(gen.unblock)
+	// Each output takes 60% of the timeout, so the batch of 2 exceeds a single timeout.
+	slow := &slowMultiGenerator{gen, 60 * time.Millisecond}
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(slow), WithGenerationTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	results, err := estl.EnqueueSet(srcs[0], []Variant{
+		{SizeFromUint(100, 100), ModeCrop, FMT_JPG},
+		{SizeFromUint(200, 200), ModeCrop, FMT_JPG},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, res := range results {
+		<-res.Done()
+		if err := res.Err(); err != nil {
+			t.Errorf("#%d: the timeout should be scaled by the number of outputs: %v", i, err)
+		}
+	}
+}
+
+// slowMultiGenerator delays each output by delay, respecting ctx.
+type slowMultiGenerator struct {
+	*multiGenerator
+	delay time.Duration
+}
+
+func (g *slowMultiGenerator) GenerateMulti(ctx context.Context, source string, outputs []Output) []error {
+	errs := make([]error, len(outputs))
+	for i, o := range outputs {
+		select {
+		case <-time.After(g.delay):
+			errs[i] = g.Generate(ctx, source, o.Size, o.Mode, o.Format, o.Path)
+		case <-ctx.Done():
+			errs[i] = context.Cause(ctx)
+		}
+	}
+	return errs
+}
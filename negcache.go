@@ -0,0 +1,103 @@
+package estelle
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CachedFailureError is returned by Enqueue when the previous generation of the thumbnail
+// failed and the failure is still cached (see WithNegativeCacheTTL).
+// The generation is not retried until the cache entry expires or the source file changes.
+type CachedFailureError struct {
+	Kind     error     // Kind of the original failure (see GenerationError.Kind)
+	Source   string    // Absolute path to the source file
+	Message  string    // Error message of the original failure
+	Stderr   string    // Standard error output of the generator, if any
+	FailedAt time.Time // When the original failure happened
+	Expires  time.Time // When the cache entry expires
+}
+
+func (e *CachedFailureError) Error() string {
+	return fmt.Sprintf("thumbnail generation failed previously (retry after %s): %s", e.Expires.Format(time.RFC3339), e.Message)
+}
+
+// Is reports whether target is the kind of the original failure.
+func (e *CachedFailureError) Is(target error) bool {
+	return e.Kind != nil && target == e.Kind
+}
+
+// failureRecord is the content of a negative cache file.
+type failureRecord struct {
+	Kind     string    `json:"kind,omitempty"`
+	Source   string    `json:"source"`
+	Message  string    `json:"message"`
+	Stderr   string    `json:"stderr,omitempty"`
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// failurePath returns the path of the negative cache file for the thumbnail.
+// A failure is cached per thumbnail rather than per source, because it may depend on
+// the size or format (e.g. GoGenerator cannot write WebP). Since the file name starts
+// with the fingerprint hash, a failure is forgotten when the source file changes.
+// It lives next to the thumbnail, so it survives restarts and is evicted by GC as well.
+func (ti ThumbInfo) failurePath() string {
+	return ti.path + ".failed"
+}
+
+// lookupFailure returns *CachedFailureError if a failure of ti is cached and not expired yet.
+func (estl *Estelle) lookupFailure(ti ThumbInfo) error {
+	if estl.negativeTTL <= 0 {
+		return nil
+	}
+	path := ti.failurePath()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var rec failureRecord
+	if err := json.Unmarshal(b, &rec); err != nil {
+		os.Remove(path) // Broken record. Just retry.
+		return nil
+	}
+	expires := rec.FailedAt.Add(estl.negativeTTL)
+	if time.Now().After(expires) {
+		os.Remove(path)
+		return nil
+	}
+	return &CachedFailureError{
+		Kind:     kindByName(rec.Kind),
+		Source:   rec.Source,
+		Message:  rec.Message,
+		Stderr:   rec.Stderr,
+		FailedAt: rec.FailedAt,
+		Expires:  expires,
+	}
+}
+
+// recordFailure saves the failure of ti in the negative cache.
+func (estl *Estelle) recordFailure(ti ThumbInfo, ge *GenerationError) {
+	if estl.negativeTTL <= 0 {
+		return
+	}
+	b, err := json.Marshal(failureRecord{
+		Kind:     kindName(ge.Kind),
+		Source:   ti.source,
+		Message:  ge.Error(),
+		Stderr:   ge.Stderr,
+		FailedAt: time.Now(),
+	})
+	if err != nil {
+		return
+	}
+	path := ti.failurePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return
+	}
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		return
+	}
+	estl.gc.Track(int64(len(b)))
+}
@@ -0,0 +1,134 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	cacheDir := filepath.Join(tmpDir, "cache")
+	srcs := makeSources(t, tmpDir, "broken.jpg")
+
+	var calls int32
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		atomic.AddInt32(&calls, 1)
+		return &GenerationError{Source: source, Stderr: "not a known file format", Err: errors.New("exit status 1")}
+	})
+	enqueue := func(estl *Estelle) error {
+		t.Helper()
+		ti, err := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := estl.Enqueue(ti)
+		if err != nil {
+			return err
+		}
+		<-res.Done()
+		return res.Err()
+	}
+
+	estl, err := New(cacheDir, WithGenerator(gen), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ge *GenerationError
+	if err := enqueue(estl); !errors.As(err, &ge) {
+		t.Fatalf("expected GenerationError, got %v", err)
+	}
+
+	var cfe *CachedFailureError
+	if err := enqueue(estl); !errors.As(err, &cfe) {
+		t.Fatalf("expected CachedFailureError, got %v", err)
+	}
+	if cfe.Stderr != "not a known file format" {
+		t.Errorf("original stderr should be kept, got %q", cfe.Stderr)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("generator called %d times, want 1", n)
+	}
+	estl.Shutdown(context.Background())
+
+	// The negative cache survives restarts.
+	estl, err = New(cacheDir, WithGenerator(gen), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	if err := enqueue(estl); !errors.As(err, &cfe) {
+		t.Fatalf("expected CachedFailureError after restart, got %v", err)
+	}
+
+	// Modifying the source invalidates the cached failure.
+	future := time.Now().Add(time.Minute)
+	if err := os.Chtimes(srcs[0], future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := enqueue(estl); !errors.As(err, &ge) {
+		t.Fatalf("expected GenerationError after modification, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("generator called %d times, want 2", n)
+	}
+}
+
+func TestNegativeCacheExpires(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "broken.jpg")
+	var calls int32
+	gen := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("broken")
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithNegativeCacheTTL(50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	time.Sleep(100 * time.Millisecond)
+	res, err = estl.Enqueue(ti)
+	if err != nil {
+		t.Fatalf("expired failure should not be returned: %v", err)
+	}
+	<-res.Done()
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Errorf("generator called %d times, want 2", n)
+	}
+}
+
+func TestNegativeCacheIgnoresTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "slow.jpg")
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithNegativeCacheTTL(time.Hour), WithGenerationTimeout(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-res.Done()
+	if !errors.Is(res.Err(), ErrGenerationTimeout) {
+		t.Fatalf("expected ErrGenerationTimeout, got %v", res.Err())
+	}
+	if _, err := os.Stat(ti.failurePath()); !os.IsNotExist(err) {
+		t.Errorf("timeout should not be cached: %v", err)
+	}
+}
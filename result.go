@@ -0,0 +1,186 @@
+package estelle
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Result represents the status of an enqueued task.
+// It follows a context-like pattern allowing select-based waiting.
+type Result struct {
+	done chan struct{} // Closed when the task finishes
+	err  error         // The resulting error, valid only after done is closed
+	meta Metadata      // Metadata of the thumbnail, valid only after done is closed
+	ti   ThumbInfo     // The thumbnail to be generated
+	job  *job          // The job queued in the scheduler
+
+	enqueuedAt time.Time // When the task was enqueued
+
+	mu        sync.Mutex
+	waiters   int                     // Number of callers still waiting for this result
+	started   bool                    // True once the task has started running
+	abandoned bool                    // True once all waiters have gone and the task is cancelled
+	onAbandon func()                  // Called when the task is abandoned before it starts
+	cancel    context.CancelCauseFunc // Cancels the context passed to the Generator
+	stops     []func() bool           // Unregisters context.AfterFunc of the waiters
+}
+
+func newResult() *Result {
+	return &Result{done: make(chan struct{}), enqueuedAt: time.Now()}
+}
+
+// closedDone is a channel that is already closed.
+// We reuse this for optimization for the case where the thumbnail already exists.
+var closedDone = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+// cachedResult returns a Result which is already completed with the thumbnail in the cache.
+func cachedResult(meta Metadata) *Result {
+	meta.CacheHit = true
+	return &Result{done: closedDone, meta: meta}
+}
+
+// failedResult returns a Result which is already completed with err.
+func failedResult(err error) *Result {
+	return &Result{done: closedDone, err: err}
+}
+
+// Done returns a channel that's closed when the task completes.
+// This allows the Result to be used in select statements.
+func (r *Result) Done() <-chan struct{} {
+	return r.done
+}
+
+// Err returns the error resulting from the task.
+// It is only safe to call after the Done channel is closed.
+func (r *Result) Err() error {
+	return r.err
+}
+
+// Metadata returns the metadata of the thumbnail, such as the actual dimensions and timings.
+// It is only valid after the Done channel is closed and Err returns nil.
+func (r *Result) Metadata() Metadata {
+	return r.meta
+}
+
+// acquire registers a new waiter which is interested in this result until ctx is done.
+// When all the waiters are gone, release is called with cancelRunning.
+// It returns false if the task has been already abandoned; the caller must not use this Result.
+func (r *Result) acquire(ctx context.Context, cancelRunning bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.abandoned {
+		return false
+	}
+	r.waiters++
+	stop := context.AfterFunc(ctx, func() { r.release(cancelRunning) })
+	r.stops = append(r.stops, stop)
+	return true
+}
+
+// release unregisters a waiter.
+// If it was the last one, the task is abandoned unless it is already running, and onAbandon is called.
+// If cancelRunning is true, the running task is also abandoned and its Generator is cancelled.
+func (r *Result) release(cancelRunning bool) {
+	// onAbandon is called without r.mu, since it locks the scheduler, which may lock r.mu in turn.
+	if r.unwait(cancelRunning) && r.onAbandon != nil {
+		r.onAbandon()
+	}
+}
+
+// unwait does the work of release except for onAbandon.
+// It returns true if the task is abandoned before it starts.
+func (r *Result) unwait(cancelRunning bool) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.waiters--
+	if r.waiters > 0 || r.abandoned {
+		return false
+	}
+	select {
+	case <-r.done:
+		return false // Already finished. Nothing to cancel.
+	default:
+	}
+	if !r.started {
+		r.abandoned = true
+		return true
+	}
+	if cancelRunning {
+		r.abandoned = true
+		r.cancel(context.Canceled)
+	}
+	return false
+}
+
+// isAbandoned reports whether the task has been abandoned.
+func (r *Result) isAbandoned() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.abandoned
+}
+
+// abort cancels the running Generator with cause, regardless of the waiters.
+func (r *Result) abort(cause error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.abandoned = true
+	if r.cancel != nil {
+		r.cancel(cause)
+	}
+}
+
+// running reports whether the task has started.
+func (r *Result) running() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.started
+}
+
+// start marks the task as running and returns the context for the Generator.
+// It returns false if the task has been abandoned before it starts.
+func (r *Result) start() (context.Context, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.abandoned {
+		return nil, false
+	}
+	r.started = true
+	ctx, cancel := context.WithCancelCause(context.Background())
+	r.cancel = cancel
+	return ctx, true
+}
+
+// finish closes the done channel and releases resources associated with the task.
+func (r *Result) finish() {
+	tryClose(r.done) // The channel may be closed already by Shutdown.
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel(nil)
+	}
+	for _, stop := range r.stops {
+		stop()
+	}
+	r.stops = nil
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func tryClose(ch chan struct{}) {
+	defer func() {
+		recover()
+	}()
+	close(ch) // calling close on a closed channel panics
+}
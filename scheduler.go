@@ -0,0 +1,365 @@
+package estelle
+
+import (
+	"context"
+	"slices"
+	"sync"
+)
+
+// Priority represents the scheduling class of a task.
+type Priority int
+
+const (
+	// PriorityInteractive is for a task which a client is synchronously waiting for.
+	// This is the default.
+	PriorityInteractive Priority = iota
+	// PriorityBackground is for a task which nobody is waiting for, such as prefetching.
+	// Background tasks run only when no interactive task is queued, except that one runs
+	// after every "starvation limit" interactive tasks (see WithStarvationLimit).
+	PriorityBackground
+
+	numPriorities = iota
+)
+
+// String returns the string representation of the priority.
+func (p Priority) String() string {
+	switch p {
+	case PriorityInteractive:
+		return "interactive"
+	case PriorityBackground:
+		return "background"
+	}
+	return "unknown"
+}
+
+// job is a task queued in scheduler.
+type job struct {
+	fn       func()
+	priority Priority
+	client   string // Identity of the client which requested this job
+	queued   bool   // True while the job is in the queue. Protected by scheduler.mu.
+
+	tasks []*Result // Tasks completed by fn, which are all for the same source
+}
+
+// abandoned reports whether all the tasks of j have been abandoned.
+func (j *job) abandoned() bool {
+	for _, res := range j.tasks {
+		if !res.isAbandoned() {
+			return false
+		}
+	}
+	return true
+}
+
+// fairQueue holds jobs of a priority class.
+// Jobs are taken from the clients in round-robin order, and jobs of the same client
+// are processed in LIFO order, since the most recent request is the most likely to be
+// still wanted (e.g. the user is scrolling a gallery).
+type fairQueue struct {
+	stacks map[string][]*job // Stack of jobs for each client
+	ring   []string          // Clients which have queued jobs, in round-robin order
+	cursor int               // Index in ring of the client to be served next
+	n      int               // Total number of queued jobs
+}
+
+func (q *fairQueue) push(j *job) {
+	if q.stacks == nil {
+		q.stacks = map[string][]*job{}
+	}
+	st, ok := q.stacks[j.client]
+	if !ok {
+		q.ring = append(q.ring, j.client)
+	}
+	q.stacks[j.client] = append(st, j)
+	q.n++
+}
+
+// pop takes the job to run next. The queue must not be empty.
+func (q *fairQueue) pop() *job {
+	client := q.ring[q.cursor]
+	st := q.stacks[client]
+	last := len(st) - 1
+	j := st[last]
+	st[last] = nil // Avoid memory leak
+	q.stacks[client] = st[:last]
+	q.n--
+	if last == 0 {
+		q.dropClient(q.cursor)
+	} else {
+		q.cursor++
+	}
+	if q.cursor >= len(q.ring) {
+		q.cursor = 0
+	}
+	return j
+}
+
+// remove removes j from the queue. It returns false if j is not found.
+func (q *fairQueue) remove(j *job) bool {
+	st := q.stacks[j.client]
+	i := slices.Index(st, j)
+	if i < 0 {
+		return false
+	}
+	st = slices.Delete(st, i, i+1)
+	q.stacks[j.client] = st
+	q.n--
+	if len(st) == 0 {
+		k := slices.Index(q.ring, j.client)
+		q.dropClient(k)
+		if k < q.cursor {
+			q.cursor--
+		}
+		if q.cursor >= len(q.ring) {
+			q.cursor = 0
+		}
+	}
+	return true
+}
+
+func (q *fairQueue) dropClient(i int) {
+	delete(q.stacks, q.ring[i])
+	q.ring = slices.Delete(q.ring, i, i+1)
+}
+
+// scheduler is a worker pool which runs interactive jobs ahead of background ones,
+// and serves the clients fairly within each priority class.
+type scheduler struct {
+	mu              sync.Mutex
+	cond            *sync.Cond
+	queues          [numPriorities]fairQueue // Queued jobs for each priority
+	perClient       map[string]int           // Number of queued jobs for each client
+	maxBuffer       int                      // 0 means unbounded
+	clientQuota     int                      // Max queued jobs per client while others have queued jobs. 0 means unbounded.
+	starvationLimit int                      // Max interactive jobs run in a row while background jobs are waiting
+	streak          int                      // Interactive jobs run in a row while background jobs are waiting
+	running         int                      // Number of jobs being executed
+	workers         int                      // Target number of workers
+	live            int                      // Number of worker goroutines alive
+	stopped         bool
+	wg              sync.WaitGroup
+	panicHandler    func(interface{})
+}
+
+func newScheduler(workers, maxBuffer, clientQuota, starvationLimit int, panicHandler func(interface{})) *scheduler {
+	s := &scheduler{
+		perClient:       map[string]int{},
+		maxBuffer:       maxBuffer,
+		clientQuota:     clientQuota,
+		starvationLimit: starvationLimit,
+		panicHandler:    panicHandler,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.SetWorkers(workers)
+	return s
+}
+
+// SetWorkers changes the number of workers. If it decreases, the extra workers exit after
+// finishing their current jobs. n less than 1 is regarded as 1.
+func (s *scheduler) SetWorkers(n int) error {
+	if n < 1 {
+		n = 1
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrEstelleClosed
+	}
+	s.workers = n
+	for ; s.live < n; s.live++ {
+		s.wg.Add(1)
+		go s.workerLoop()
+	}
+	s.cond.Broadcast() // Wake up idle workers so that extra ones exit
+	return nil
+}
+
+// Workers returns the number of workers set by SetWorkers.
+func (s *scheduler) Workers() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.workers
+}
+
+// len returns the number of queued jobs. The caller must hold s.mu.
+func (s *scheduler) len() int {
+	n := 0
+	for i := range s.queues {
+		n += s.queues[i].n
+	}
+	return n
+}
+
+// Load returns the number of queued jobs and the number of jobs being executed.
+func (s *scheduler) Load() (queued, running int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.len(), s.running
+}
+
+// Submit adds a job to the queue.
+// It is non-blocking. If the queue is full, it returns ErrEstelleQueueFull.
+// If the client has already queued as many jobs as its quota while other clients also have queued
+// jobs, it returns ErrEstelleClientQuota. A client alone can use the whole queue.
+// If the scheduler is stopped, it returns ErrEstelleClosed.
+func (s *scheduler) Submit(j *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return ErrEstelleClosed
+	}
+	// perClient has only the clients with queued jobs, including this one if it is over quota.
+	if s.clientQuota > 0 && s.perClient[j.client] >= s.clientQuota && len(s.perClient) > 1 {
+		return ErrEstelleClientQuota
+	}
+	if s.maxBuffer > 0 && s.len() >= s.maxBuffer {
+		return ErrEstelleQueueFull
+	}
+	j.queued = true
+	s.queues[j.priority].push(j)
+	s.perClient[j.client]++
+	s.cond.Signal()
+	return nil
+}
+
+// Promote raises the priority of a queued job to p.
+// It does nothing if the job has already started or its priority is already p or higher.
+func (s *scheduler) Promote(j *job, p Priority) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !j.queued || j.priority <= p {
+		return
+	}
+	if s.queues[j.priority].remove(j) {
+		j.priority = p
+		s.queues[p].push(j)
+	}
+}
+
+// Priority returns the current priority of j, which may have been raised by Promote.
+func (s *scheduler) Priority(j *job) Priority {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return j.priority
+}
+
+// Take removes a queued job, so that the caller can run it together with the job like.
+// Only a job of the same client and priority as like is taken, so that the priority and the fairness
+// across the clients are preserved. It returns false if the job is not queued, i.e. it has already
+// started or been discarded, or it is not of the same client and priority.
+func (s *scheduler) Take(j, like *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !j.queued || j.client != like.client || j.priority != like.priority || !s.queues[j.priority].remove(j) {
+		return false
+	}
+	s.dequeued(j)
+	return true
+}
+
+// Drop removes a queued job whose tasks have all been abandoned, so that it no longer occupies
+// the queue and the quota of its client. It returns false if the job is not queued or any of
+// its tasks is still wanted.
+func (s *scheduler) Drop(j *job) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// j.tasks can be read only after j is queued. See Take.
+	if !j.queued || !j.abandoned() || !s.queues[j.priority].remove(j) {
+		return false
+	}
+	s.dequeued(j)
+	return true
+}
+
+// dequeued updates the bookkeeping for j removed from the queue. The caller must hold s.mu.
+func (s *scheduler) dequeued(j *job) {
+	j.queued = false
+	if s.perClient[j.client]--; s.perClient[j.client] == 0 {
+		delete(s.perClient, j.client)
+	}
+}
+
+// next pops the job to run next. The caller must hold s.mu, and the queue must not be empty.
+func (s *scheduler) next() *job {
+	fg, bg := &s.queues[PriorityInteractive], &s.queues[PriorityBackground]
+	q := fg
+	if bg.n == 0 {
+		s.streak = 0
+	} else if fg.n == 0 || s.streak >= s.starvationLimit {
+		q = bg
+		s.streak = 0
+	} else {
+		s.streak++
+	}
+	j := q.pop()
+	s.dequeued(j)
+	return j
+}
+
+func (s *scheduler) workerLoop() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		for s.len() == 0 && !s.stopped && s.live <= s.workers {
+			s.cond.Wait()
+		}
+		// If stopped is true, the queue has been discarded and we just exit.
+		// If there are more workers than needed, this one retires.
+		if s.len() == 0 || s.live > s.workers {
+			s.live--
+			s.mu.Unlock()
+			return
+		}
+		j := s.next()
+		s.running++
+		s.mu.Unlock()
+
+		// Execute job outside lock
+		func() {
+			defer func() {
+				if p := recover(); p != nil {
+					if s.panicHandler != nil {
+						s.panicHandler(p)
+					}
+				}
+			}()
+			j.fn()
+		}()
+		s.mu.Lock()
+		s.running--
+		s.mu.Unlock()
+	}
+}
+
+// Shutdown discards queued jobs and waits for the workers to finish the running ones.
+// It respects the provided context for timeout or cancellation.
+func (s *scheduler) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.stopped {
+		s.stopped = true
+		for i := range s.queues {
+			for _, st := range s.queues[i].stacks {
+				for _, j := range st {
+					j.queued = false
+				}
+			}
+			s.queues[i] = fairQueue{} // Discard queued jobs
+		}
+		clear(s.perClient)
+		s.cond.Broadcast() // Wake up ALL workers so they check 'stopped'
+	}
+	s.mu.Unlock()
+
+	c := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(c)
+	}()
+	select {
+	case <-c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
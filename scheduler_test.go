@@ -0,0 +1,189 @@
+package estelle
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// runOrder submits jobs to a scheduler with a single worker which is kept busy
+// until all the jobs are queued, and returns the order in which they have run.
+func runOrder(t *testing.T, starvationLimit int, submit func(s *scheduler, mk func(name string, p Priority) *job)) []string {
+	t.Helper()
+	s := newScheduler(1, 0, 0, starvationLimit, nil)
+	defer s.Shutdown(context.Background())
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	block := make(chan struct{})
+	started := make(chan struct{})
+	wg.Add(1)
+	s.Submit(&job{fn: func() { defer wg.Done(); close(started); <-block }})
+	<-started
+
+	mk := func(name string, p Priority) *job {
+		wg.Add(1)
+		return &job{priority: p, fn: func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}}
+	}
+	submit(s, mk)
+	close(block)
+	wg.Wait()
+	return order
+}
+
+func TestSchedulerPriority(t *testing.T) {
+	order := runOrder(t, 8, func(s *scheduler, mk func(string, Priority) *job) {
+		s.Submit(mk("b1", PriorityBackground))
+		s.Submit(mk("i1", PriorityInteractive))
+		s.Submit(mk("b2", PriorityBackground))
+		s.Submit(mk("i2", PriorityInteractive))
+	})
+	want := []string{"i2", "i1", "b2", "b1"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v, but got %v", want, order)
+	}
+}
+
+func TestSchedulerStarvationLimit(t *testing.T) {
+	order := runOrder(t, 1, func(s *scheduler, mk func(string, Priority) *job) {
+		s.Submit(mk("b1", PriorityBackground))
+		s.Submit(mk("b2", PriorityBackground))
+		s.Submit(mk("i1", PriorityInteractive))
+		s.Submit(mk("i2", PriorityInteractive))
+		s.Submit(mk("i3", PriorityInteractive))
+	})
+	want := []string{"i3", "b2", "i2", "b1", "i1"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v, but got %v", want, order)
+	}
+}
+
+func TestSchedulerPromote(t *testing.T) {
+	order := runOrder(t, 8, func(s *scheduler, mk func(string, Priority) *job) {
+		b1 := mk("b1", PriorityBackground)
+		s.Submit(b1)
+		s.Submit(mk("b2", PriorityBackground))
+		s.Submit(mk("i1", PriorityInteractive))
+		s.Promote(b1, PriorityInteractive)
+	})
+	want := []string{"b1", "i1", "b2"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v, but got %v", want, order)
+	}
+}
+
+func TestSchedulerQueueFull(t *testing.T) {
+	s := newScheduler(1, 1, 0, 8, nil)
+	block := make(chan struct{})
+	started := make(chan struct{})
+	s.Submit(&job{fn: func() { close(started); <-block }})
+	<-started
+	if err := s.Submit(&job{fn: func() {}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Submit(&job{fn: func() {}, priority: PriorityBackground}); err != ErrEstelleQueueFull {
+		t.Errorf("expected ErrEstelleQueueFull, but got %v", err)
+	}
+	close(block)
+	s.Shutdown(context.Background())
+	if err := s.Submit(&job{fn: func() {}}); err != ErrEstelleClosed {
+		t.Errorf("expected ErrEstelleClosed, but got %v", err)
+	}
+}
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	order := runOrder(t, 8, func(s *scheduler, mk func(string, Priority) *job) {
+		for _, name := range []string{"a1", "a2", "a3", "a4"} {
+			j := mk(name, PriorityInteractive)
+			j.client = "a"
+			s.Submit(j)
+		}
+		for _, name := range []string{"b1", "b2"} {
+			j := mk(name, PriorityInteractive)
+			j.client = "b"
+			s.Submit(j)
+		}
+		j := mk("c1", PriorityInteractive)
+		j.client = "c"
+		s.Submit(j)
+	})
+	want := []string{"a4", "b2", "c1", "a3", "b1", "a2", "a1"}
+	if !slices.Equal(order, want) {
+		t.Errorf("expected %v, but got %v", want, order)
+	}
+}
+
+func TestSchedulerClientQuota(t *testing.T) {
+	s := newScheduler(1, 10, 2, 8, nil)
+	defer s.Shutdown(context.Background())
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	s.Submit(&job{fn: func() { close(started); <-block }})
+	<-started
+
+	// The quota is not applied while the client is alone.
+	for i := 0; i < 3; i++ {
+		if err := s.Submit(&job{fn: func() {}, client: "greedy"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Submit(&job{fn: func() {}, client: "polite"}); err != nil {
+		t.Errorf("other clients should not be affected: %v", err)
+	}
+	if err := s.Submit(&job{fn: func() {}, client: "greedy", priority: PriorityBackground}); err != ErrEstelleClientQuota {
+		t.Errorf("expected ErrEstelleClientQuota, but got %v", err)
+	}
+	if err := s.Submit(&job{fn: func() {}, client: "polite"}); err != nil {
+		t.Errorf("other clients should not be affected: %v", err)
+	}
+}
+
+func TestSchedulerTake(t *testing.T) {
+	s := newScheduler(1, 0, 0, 8, nil)
+	defer s.Shutdown(context.Background())
+	block := make(chan struct{})
+	defer close(block)
+	started := make(chan struct{})
+	running := &job{fn: func() { close(started); <-block }, client: "a"}
+	s.Submit(running)
+	<-started
+
+	other := &job{fn: func() {}, client: "b"}
+	background := &job{fn: func() {}, client: "a", priority: PriorityBackground}
+	same := &job{fn: func() {}, client: "a"}
+	for _, j := range []*job{other, background, same} {
+		if err := s.Submit(j); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Take(other, running) {
+		t.Error("a job of another client must not be taken")
+	}
+	if s.Take(background, running) {
+		t.Error("a job of another priority must not be taken")
+	}
+	if !s.Take(same, running) {
+		t.Error("a job of the same client and priority should be taken")
+	}
+	if s.Take(same, running) {
+		t.Error("a job must not be taken twice")
+	}
+}
+
+func TestWithStarvationLimit(t *testing.T) {
+	for _, n := range []int{-1, 0, 1} {
+		var c config
+		WithStarvationLimit(n)(&c)
+		if c.starvationLimit != 1 {
+			t.Errorf("WithStarvationLimit(%d): expected 1, got %d", n, c.starvationLimit)
+		}
+	}
+}
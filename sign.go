@@ -0,0 +1,82 @@
+package estelle
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Errors returned by VerifyQuery.
+var (
+	// ErrSignatureInvalid means that the signature is missing or malformed, does not match
+	// any of the keys, or the query has parameters which are not covered by the signature.
+	ErrSignatureInvalid = errors.New("invalid signature")
+	// ErrSignatureExpired means that the signature is valid, but it has expired.
+	ErrSignatureExpired = errors.New("signature expired")
+)
+
+// signedParams are the query parameters covered by the signature, except for "sig" itself.
+var signedParams = []string{"source", "size", "mode", "format", "exp"}
+
+// SignQuery returns the query parameters to request the thumbnail of source from the endpoint
+// of estelled at path, e.g. "/thumb", signed with key and valid until expires. The signature is
+// valid only for path, so the result must be appended to it: path + "?" + SignQuery(...).Encode().
+func SignQuery(key []byte, path string, source string, size Size, mode Mode, format Format, expires time.Time) url.Values {
+	query := url.Values{}
+	query.Set("source", source)
+	query.Set("size", size.String())
+	query.Set("mode", mode.String())
+	query.Set("format", format.String())
+	query.Set("exp", strconv.FormatInt(expires.Unix(), 10))
+	query.Set("sig", base64.RawURLEncoding.EncodeToString(signature(key, path, query)))
+	return query
+}
+
+// VerifyQuery checks that query of the request to path is signed with one of keys by SignQuery
+// and not expired at now. Multiple keys are accepted so that they can be rotated without
+// invalidating issued URLs.
+func VerifyQuery(keys [][]byte, path string, query url.Values, now time.Time) error {
+	for name, values := range query {
+		if len(values) != 1 || name != "sig" && !slices.Contains(signedParams, name) {
+			return ErrSignatureInvalid
+		}
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(query.Get("sig"))
+	if err != nil || len(sig) != sha256.Size {
+		return ErrSignatureInvalid
+	}
+	exp, err := strconv.ParseInt(query.Get("exp"), 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	// Try all the keys regardless of the result, so that the timing does not tell which one matched.
+	ok := false
+	for _, key := range keys {
+		if hmac.Equal(sig, signature(key, path, query)) {
+			ok = true
+		}
+	}
+	if !ok {
+		return ErrSignatureInvalid
+	}
+	if now.Unix() > exp {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// signature computes HMAC-SHA256 of path and the signed parameters in query.
+// They are URL-encoded in a fixed order, so that values containing separators are not ambiguous.
+func signature(key []byte, path string, query url.Values) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(url.QueryEscape(path)))
+	for _, name := range signedParams {
+		mac.Write([]byte("&" + name + "=" + url.QueryEscape(query.Get(name))))
+	}
+	return mac.Sum(nil)
+}
@@ -0,0 +1,50 @@
+package estelle
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignQuery(t *testing.T) {
+	oldKey, newKey := []byte("old"), []byte("new")
+	now := time.Unix(1700000000, 0)
+	signed := func() url.Values {
+		return SignQuery(newKey, "/thumb", "/foo/bar & baz.jpg", SizeFromUint(400, 300), ModeCrop, FMT_WEBP, now.Add(time.Hour))
+	}
+
+	cases := []struct {
+		name   string
+		path   string
+		keys   [][]byte
+		modify func(url.Values)
+		now    time.Time
+		want   error
+	}{
+		{"valid", "/thumb", [][]byte{newKey}, func(url.Values) {}, now, nil},
+		{"rotated", "/thumb", [][]byte{oldKey, newKey}, func(url.Values) {}, now, nil},
+		{"unknown key", "/thumb", [][]byte{oldKey}, func(url.Values) {}, now, ErrSignatureInvalid},
+		{"expired", "/thumb", [][]byte{newKey}, func(url.Values) {}, now.Add(2 * time.Hour), ErrSignatureExpired},
+		{"other endpoint", "/get", [][]byte{newKey}, func(url.Values) {}, now, ErrSignatureInvalid},
+		{"tampered source", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Set("source", "/etc/passwd") }, now, ErrSignatureInvalid},
+		{"tampered size", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Set("size", "4000x3000") }, now, ErrSignatureInvalid},
+		{"omitted mode", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Del("mode") }, now, ErrSignatureInvalid},
+		{"extended expiry", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Set("exp", "9999999999") }, now, ErrSignatureInvalid},
+		{"unsigned parameter", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Set("key", "x") }, now, ErrSignatureInvalid},
+		{"duplicate parameter", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Add("source", "/etc/passwd") }, now, ErrSignatureInvalid},
+		{"malformed signature", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Set("sig", "!!") }, now, ErrSignatureInvalid},
+		{"no signature", "/thumb", [][]byte{newKey}, func(q url.Values) { q.Del("sig") }, now, ErrSignatureInvalid},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			q := signed()
+			tc.modify(q)
+			// Round trip through the URL encoding, as in real requests
+			q, _ = url.ParseQuery(q.Encode())
+			if err := VerifyQuery(tc.keys, tc.path, q, tc.now); !errors.Is(err, tc.want) {
+				t.Errorf("expected %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
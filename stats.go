@@ -0,0 +1,126 @@
+package estelle
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the statistics of Estelle since it was created.
+type Stats struct {
+	Hits           uint64 // Requests served from the cache
+	Misses         uint64 // Requests which started a new generation
+	CoalescedWaits uint64 // Requests which joined the pending generation of the same thumbnail
+	QueueFull      uint64 // Requests rejected because the queue was full
+	QuotaExceeded  uint64 // Requests rejected because the client quota was exceeded
+	Generated      uint64 // Successful generations
+	Failed         uint64 // Failed generations, excluding cancelled ones
+
+	Queued  int // Jobs waiting in the queue at the moment
+	Running int // Jobs being executed at the moment
+
+	CacheBytes   int64  // Total size of the cache directory tracked by the garbage collector
+	CacheLimit   int64  // Cache size limit set by WithCacheLimit
+	GCRuns       uint64 // Garbage collection cycles
+	Evictions    uint64 // Thumbnails removed by the garbage collector
+	EvictedBytes uint64 // Total size of the evicted thumbnails
+
+	// GenerationTime is the distribution of the time taken by successful generations
+	// for each combination of format and mode.
+	GenerationTime map[FormatMode]Histogram
+}
+
+// FormatMode is a key of Stats.GenerationTime.
+type FormatMode struct {
+	Format Format
+	Mode   Mode
+}
+
+// Histogram is a cumulative histogram of durations in the same manner as Prometheus.
+type Histogram struct {
+	Bounds []time.Duration // Upper bounds of the buckets in ascending order
+	Counts []uint64        // Counts[i] is the number of observations less than or equal to Bounds[i]
+	Count  uint64          // Total number of observations, including those greater than the last bound
+	Sum    time.Duration   // Sum of all observations
+}
+
+var histogramBounds = []time.Duration{
+	10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second,
+	10 * time.Second, 30 * time.Second, time.Minute,
+}
+
+// stats holds the counters of Estelle updated on the fly.
+type stats struct {
+	hits, misses, coalesced  atomic.Uint64
+	queueFull, quotaExceeded atomic.Uint64
+	generated, failed        atomic.Uint64
+	mu                       sync.Mutex
+	genTime                  map[FormatMode]*histogram
+}
+
+// histogram is not cumulative, unlike Histogram. The last bucket is for observations
+// greater than the last bound.
+type histogram struct {
+	buckets []uint64
+	sum     time.Duration
+}
+
+func (s *stats) observe(key FormatMode, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.genTime == nil {
+		s.genTime = map[FormatMode]*histogram{}
+	}
+	h, ok := s.genTime[key]
+	if !ok {
+		h = &histogram{buckets: make([]uint64, len(histogramBounds)+1)}
+		s.genTime[key] = h
+	}
+	i := sort.Search(len(histogramBounds), func(i int) bool { return d <= histogramBounds[i] })
+	h.buckets[i]++
+	h.sum += d
+}
+
+func (s *stats) histograms() map[FormatMode]Histogram {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	hs := make(map[FormatMode]Histogram, len(s.genTime))
+	for key, h := range s.genTime {
+		counts := make([]uint64, len(histogramBounds))
+		var total uint64
+		for i, n := range h.buckets {
+			total += n
+			if i < len(counts) {
+				counts[i] = total
+			}
+		}
+		hs[key] = Histogram{Bounds: histogramBounds, Counts: counts, Count: total, Sum: h.sum}
+	}
+	return hs
+}
+
+// Stats returns the statistics of Estelle at the moment.
+func (estl *Estelle) Stats() Stats {
+	queued, running := estl.runner.Load()
+	limit, _, _ := estl.gc.Limit()
+	return Stats{
+		Hits:           estl.stats.hits.Load(),
+		Misses:         estl.stats.misses.Load(),
+		CoalescedWaits: estl.stats.coalesced.Load(),
+		QueueFull:      estl.stats.queueFull.Load(),
+		QuotaExceeded:  estl.stats.quotaExceeded.Load(),
+		Generated:      estl.stats.generated.Load(),
+		Failed:         estl.stats.failed.Load(),
+		Queued:         queued,
+		Running:        running,
+		CacheBytes:     atomic.LoadInt64(&estl.gc.used),
+		CacheLimit:     limit,
+		GCRuns:         atomic.LoadUint64(&estl.gc.runs),
+		Evictions:      atomic.LoadUint64(&estl.gc.evictions),
+		EvictedBytes:   atomic.LoadUint64(&estl.gc.evictedBytes),
+		GenerationTime: estl.stats.histograms(),
+	}
+}
@@ -0,0 +1,94 @@
+package estelle
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg", "c.jpg")
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithWorkers(1), WithBufferSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	var tis []ThumbInfo
+	for _, src := range srcs {
+		ti, _ := estl.NewThumbInfo(src, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+		tis = append(tis, ti)
+	}
+
+	resA, err := estl.Enqueue(tis[0]) // Miss, running
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+	if _, err := estl.Enqueue(tis[0]); err != nil { // Coalesced
+		t.Fatal(err)
+	}
+	resB, err := estl.Enqueue(tis[1]) // Miss, queued
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := estl.Enqueue(tis[2]); err != ErrEstelleQueueFull { // Miss, rejected
+		t.Fatalf("expected ErrEstelleQueueFull, got %v", err)
+	}
+	st := estl.Stats()
+	if st.Queued != 1 || st.Running != 1 {
+		t.Errorf("expected 1 queued and 1 running, got %d and %d", st.Queued, st.Running)
+	}
+
+	close(gen.unblock)
+	<-resA.Done()
+	<-resB.Done()
+	if _, err := estl.Enqueue(tis[0]); err != nil { // Hit
+		t.Fatal(err)
+	}
+
+	// Running is decremented after the Result is done
+	deadline := time.Now().Add(time.Second)
+	for estl.Stats().Running != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st = estl.Stats()
+	want := Stats{Hits: 1, Misses: 3, CoalescedWaits: 1, QueueFull: 1, Generated: 2}
+	if st.Hits != want.Hits || st.Misses != want.Misses || st.CoalescedWaits != want.CoalescedWaits ||
+		st.QueueFull != want.QueueFull || st.Generated != want.Generated || st.Failed != 0 {
+		t.Errorf("unexpected counters: %+v", st)
+	}
+	if st.Queued != 0 || st.Running != 0 {
+		t.Errorf("expected idle, got %d queued and %d running", st.Queued, st.Running)
+	}
+	h, ok := st.GenerationTime[FormatMode{FMT_JPG, ModeCrop}]
+	if !ok || h.Count != 2 || h.Counts[len(h.Counts)-1] != 2 || len(h.Bounds) != len(h.Counts) {
+		t.Errorf("unexpected histogram: %+v", h)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	var s stats
+	key := FormatMode{FMT_PNG, ModeShrink}
+	for _, d := range []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 200 * time.Millisecond, 2 * time.Minute} {
+		s.observe(key, d)
+	}
+	h := s.histograms()[key]
+	if h.Count != 4 || h.Sum != 2*time.Minute+215*time.Millisecond {
+		t.Errorf("unexpected count and sum: %d, %s", h.Count, h.Sum)
+	}
+	for i, bound := range h.Bounds {
+		var want uint64
+		switch {
+		case bound < 200*time.Millisecond:
+			want = 2
+		default:
+			want = 3
+		}
+		if h.Counts[i] != want {
+			t.Errorf("bucket %s: expected %d, got %d", bound, want, h.Counts[i])
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package estelle
+
+import "fmt"
+
+// JobState represents the state of a thumbnail generation job.
+type JobState int
+
+const (
+	// JobUnknown means that the job is neither pending nor cached. It may have never been
+	// enqueued, or it failed and the failure is not remembered.
+	JobUnknown JobState = iota
+	// JobQueued means that the job is waiting in the queue.
+	JobQueued
+	// JobRunning means that the thumbnail is being generated.
+	JobRunning
+	// JobDone means that the thumbnail exists in the cache.
+	JobDone
+	// JobFailed means that the generation failed and the failure is remembered by the negative cache.
+	JobFailed
+)
+
+// String returns the string representation of the state.
+func (s JobState) String() string {
+	switch s {
+	case JobUnknown:
+		return "unknown"
+	case JobQueued:
+		return "queued"
+	case JobRunning:
+		return "running"
+	case JobDone:
+		return "done"
+	case JobFailed:
+		return "failed"
+	}
+	panic(fmt.Sprintf("Unknown job state: %d", s))
+}
+
+// JobStatus is the status of a job returned by Estelle.Status.
+type JobStatus struct {
+	Info     ThumbInfo // The thumbnail. Its source is not known.
+	State    JobState
+	Metadata Metadata // Metadata of the thumbnail, if State is JobDone
+	Err      error    // *CachedFailureError, if State is JobFailed
+	Result   *Result  // The pending task to wait for, if State is JobQueued or JobRunning
+}
+
+// Status returns the status of the job specified by id, which is the ID of the thumbnail
+// (i.e. ThumbInfo.String()). Use Result.Done to wait for a pending job.
+// It returns an error only if id is malformed.
+func (estl *Estelle) Status(id string) (JobStatus, error) {
+	ti, err := estl.dir.FromID(id)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	st := JobStatus{Info: ti}
+	if p := estl.pendingTasks.Load(); p != nil {
+		if res, ok := p.Get(id); ok && !isClosed(res.done) {
+			st.Result = res
+			if res.running() {
+				st.State = JobRunning
+			} else {
+				st.State = JobQueued
+			}
+			return st, nil
+		}
+	}
+	if ti.Exists() {
+		if meta, err := ti.Metadata(); err == nil {
+			meta.CacheHit = true
+			st.State, st.Metadata = JobDone, meta
+			return st, nil
+		}
+	}
+	if err := estl.lookupFailure(ti); err != nil {
+		st.State, st.Err = JobFailed, err
+	}
+	return st, nil
+}
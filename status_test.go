@@ -0,0 +1,93 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	tmpDir := t.TempDir()
+	srcs := makeSources(t, tmpDir, "a.jpg", "b.jpg", "broken.jpg")
+	gen := newBlockingGenerator()
+	failing := GeneratorFunc(func(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+		if filepath.Base(source) == "broken.jpg" {
+			return errors.New("broken")
+		}
+		return gen.Generate(ctx, source, size, mode, format, output)
+	})
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(failing), WithWorkers(1), WithNegativeCacheTTL(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	ti := make([]ThumbInfo, len(srcs))
+	for i, src := range srcs {
+		ti[i], _ = estl.NewThumbInfo(src, SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	}
+	state := func(ti ThumbInfo) JobState {
+		t.Helper()
+		st, err := estl.Status(ti.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.State
+	}
+
+	if s := state(ti[0]); s != JobUnknown {
+		t.Errorf("expected unknown, got %s", s)
+	}
+	res0, _ := estl.Enqueue(ti[0])
+	<-gen.started
+	res1, _ := estl.Enqueue(ti[1])
+	if s := state(ti[0]); s != JobRunning {
+		t.Errorf("expected running, got %s", s)
+	}
+	if s := state(ti[1]); s != JobQueued {
+		t.Errorf("expected queued, got %s", s)
+	}
+	st, _ := estl.Status(ti[1].String())
+	if st.Result != res1 {
+		t.Errorf("expected the pending Result")
+	}
+
+	close(gen.unblock)
+	<-res0.Done()
+	<-res1.Done()
+	if s := state(ti[0]); s != JobDone {
+		t.Errorf("expected done, got %s", s)
+	}
+
+	res2, _ := estl.Enqueue(ti[2])
+	<-res2.Done()
+	st, _ = estl.Status(ti[2].String())
+	var cfe *CachedFailureError
+	if st.State != JobFailed || !errors.As(st.Err, &cfe) {
+		t.Errorf("expected failed with CachedFailureError, got %s %v", st.State, st.Err)
+	}
+}
+
+func TestStatusInvalidID(t *testing.T) {
+	estl, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+	for _, id := range []string{
+		"",
+		"../../etc/passwd",
+		"0123456789abcdef0123456789abcdef01234567-100x100-crop.jpg/../x",
+		"0123456789abcdef0123456789abcdef01234567-100x100-unknown.jpg",
+		"0123456789abcdef0123456789abcdef01234567-100x100-crop.gif",
+		"0123456789abcdef0123456789abcdef01234567-0100x100-crop.jpg",
+	} {
+		if _, err := estl.Status(id); err == nil {
+			t.Errorf("expected error for %q", id)
+		}
+	}
+	if _, err := estl.Status("0123456789abcdef0123456789abcdef01234567-100x100-crop.jpg"); err != nil {
+		t.Errorf("valid ID is rejected: %v", err)
+	}
+}
@@ -1,11 +1,13 @@
 package estelle
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -35,24 +37,34 @@ func NewThumbInfoFactory(baseDir string) (ThumbInfoFactory, error) {
 	if err != nil {
 		return "", err
 	}
-	if stat, err := os.Stat(absPath); err != nil {
-		if !os.IsNotExist(err) {
-			return "", err
-		}
+	if _, err := os.Stat(absPath); os.IsNotExist(err) {
 		err = os.MkdirAll(absPath, 0755)
 		if err != nil {
 			return "", err
 		}
+	}
+	dir := ThumbInfoFactory(absPath)
+	if err := dir.Check(); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Check checks if the base directory exists and is writable.
+func (dir ThumbInfoFactory) Check() error {
+	absPath := string(dir)
+	if stat, err := os.Stat(absPath); err != nil {
+		return err
 	} else if !stat.IsDir() {
-		return "", fmt.Errorf(`"%s" exists, but it is not a directory`, absPath)
+		return fmt.Errorf(`"%s" exists, but it is not a directory`, absPath)
 	}
 	temp, err := os.CreateTemp(absPath, "estelle-test-*")
 	if err != nil {
-		return "", fmt.Errorf("cache directory (%s) is not writable: %s", absPath, err)
+		return fmt.Errorf("cache directory (%s) is not writable: %s", absPath, err)
 	}
 	temp.Close()
 	os.Remove(temp.Name())
-	return ThumbInfoFactory(absPath), nil
+	return nil
 }
 
 // FromFile creates a new ThumbInfo from the given path.
@@ -78,6 +90,35 @@ func (dir ThumbInfoFactory) FromFile(path string, size Size, mode Mode, format F
 	}, nil
 }
 
+var regexpID = regexp.MustCompile(`^([0-9a-f]{40})-([0-9]+x[0-9]+)-([a-z]+)\.([a-z]+)$`)
+
+// FromID creates a ThumbInfo from the thumbnail ID, i.e. the value of ThumbInfo.String().
+// The returned ThumbInfo does not know its source file, so it can only be used to look up
+// the cache, not to generate the thumbnail.
+func (dir ThumbInfoFactory) FromID(id string) (ThumbInfo, error) {
+	m := regexpID.FindStringSubmatch(id)
+	if m == nil {
+		return ThumbInfo{}, fmt.Errorf("invalid thumbnail ID: %q", id)
+	}
+	size, err := SizeFromString(m[2])
+	if err != nil {
+		return ThumbInfo{}, fmt.Errorf("invalid thumbnail ID: %q", id)
+	}
+	mode := ModeFromString(m[3])
+	format := FormatFromString(m[4])
+	if mode == ModeUnknown || format == FMT_UNKNOWN || fmt.Sprintf("%s-%s-%s.%s", m[1], size, mode, format) != id {
+		return ThumbInfo{}, fmt.Errorf("invalid thumbnail ID: %q", id)
+	}
+	hash := m[1]
+	return ThumbInfo{
+		id:     id,
+		path:   filepath.Join(string(dir), hash[:2], hash[2:4], id),
+		size:   size,
+		mode:   mode,
+		format: format,
+	}, nil
+}
+
 // String returns the ID of the thumbnail.
 func (ti ThumbInfo) String() string {
 	return ti.id
@@ -88,6 +129,11 @@ func (ti ThumbInfo) Path() string {
 	return ti.path
 }
 
+// Source returns the absolute path of the source file. It is empty if ti is created by FromID.
+func (ti ThumbInfo) Source() string {
+	return ti.source
+}
+
 // Exists returns true if the thumbnail file exists and is a regular file.
 func (ti ThumbInfo) Exists() bool {
 	st, err := os.Stat(ti.path)
@@ -108,56 +154,97 @@ func (ti ThumbInfo) Exists() bool {
 	return true
 }
 
-// make executes the generation of the thumbnail.
-func (ti ThumbInfo) make() error {
+// make executes the generation of the thumbnail using gen.
+// Failures are returned as *GenerationError with their kind classified if possible,
+// except when ctx is done.
+func (ti ThumbInfo) make(ctx context.Context, gen Generator) error {
+	return ti.makeFrom(ctx, gen, ti.source)
+}
+
+// makeFrom is the same as make, but generates the thumbnail from the image at src instead of the source.
+func (ti ThumbInfo) makeFrom(ctx context.Context, gen Generator, src string) error {
+	tmpName, err := ti.prepareOutput()
+	if err != nil {
+		return err
+	}
+	return ti.commitOutput(ctx, tmpName, gen.Generate(ctx, src, ti.size, ti.mode, ti.format, tmpName))
+}
+
+// makeAll executes the generation of the thumbnails in tis, which are all for the same source,
+// at once using gen. It returns the error for each of tis in the same way as make.
+func makeAll(ctx context.Context, gen MultiGenerator, tis []ThumbInfo) []error {
+	errs := make([]error, len(tis))
+	var outputs []Output
+	var idx []int // Index in tis of each output
+	for i, ti := range tis {
+		tmpName, err := ti.prepareOutput()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		outputs = append(outputs, Output{Variant{ti.size, ti.mode, ti.format}, tmpName})
+		idx = append(idx, i)
+	}
+	if len(outputs) == 0 {
+		return errs
+	}
+	genErrs := gen.GenerateMulti(ctx, tis[0].source, outputs)
+	for k, i := range idx {
+		errs[i] = tis[i].commitOutput(ctx, outputs[k].Path, genErrs[k])
+	}
+	return errs
+}
+
+// prepareOutput makes sure that the directory of the thumbnail exists,
+// and returns the temporary path to write the thumbnail.
+func (ti ThumbInfo) prepareOutput() (string, error) {
 	// Make sure that sharding directories (cachedir/XX/XX/) exist.
 	dir := filepath.Dir(ti.path)
 	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
+		return "", ti.fsError(err)
 	}
 	// Generate the thumbnail using a temporary filename and rename it to the target name after completion.
 	// This prevents incomplete (corrupted) thumbnail files from being recognized as valid.
 	// We simply prepend "incomplete_" to the filename. This is sufficient to avoid conflicts
 	// because Estelle.Enqueue() ensures only one generation process runs at a time for the same thumbnail.
-	tmpName := filepath.Join(dir, "incomplete_"+filepath.Base(ti.path))
-
-	params := ti.prepareVipsArgs(tmpName)
-	cmd := exec.Command("vipsthumbnail", params...)
-
-	// Capture stderr for debugging
-	stderr := bytes.NewBuffer([]byte{})
-	cmd.Stderr = stderr
+	return filepath.Join(dir, "incomplete_"+filepath.Base(ti.path)), nil
+}
 
-	err := cmd.Run() // block until the command completes.
+// commitOutput renames the temporary file to the thumbnail if err, the result of the Generator, is nil.
+// Otherwise, it removes the temporary file and returns the error wrapped into *GenerationError.
+func (ti ThumbInfo) commitOutput(ctx context.Context, tmpName string, err error) error {
 	if err != nil {
-		return fmt.Errorf("vipsthumbnail failed: %s: %w", stderr.String(), err)
+		os.Remove(tmpName) // Don't leave a partial output behind
+		if ctx.Err() != nil {
+			return err
+		}
+		var ge *GenerationError
+		if !errors.As(err, &ge) {
+			return &GenerationError{Kind: classify(err, ""), Source: ti.source, Err: err}
+		}
+		if ge.Kind == nil {
+			ge.Kind = classify(ge.Err, ge.Stderr)
+		}
+		return err
 	}
 
 	if err := os.Rename(tmpName, ti.path); err != nil {
-		return err
+		return ti.fsError(err)
 	}
 	return nil
 }
 
-func (ti ThumbInfo) prepareVipsArgs(outputPath string) []string {
-	// vipsthumbnail [flags] sourcefile -o outputfile
-	args := []string{ti.source}
+// hash returns the fingerprint hash of the source, which is the prefix of the ID.
+func (ti ThumbInfo) hash() string {
+	hash, _, _ := strings.Cut(ti.id, "-")
+	return hash
+}
 
-	sizeStr := ti.size.String()
-	// Size logic
-	// vipsthumbnail source.img --size WxH
-	// ModeCrop: --smartcrop=attention
-	// ModeShrink: default
-	// ModeStretch: Postfix "!" to size
-	switch ti.mode {
-	case ModeCrop:
-		args = append(args, "--smartcrop", "attention")
-	case ModeStretch:
-		sizeStr += "!"
+// fsError wraps an error on the cache directory into *GenerationError if it is a known kind
+// such as ErrDiskFull, otherwise returns it as is.
+func (ti ThumbInfo) fsError(err error) error {
+	if kind := classify(err, ""); kind != nil {
+		return &GenerationError{Kind: kind, Source: ti.source, Err: err}
 	}
-	args = append(args, "--size", sizeStr)
-
-	args = append(args, "-o", outputPath)
-
-	return args
+	return err
 }
@@ -1,6 +1,7 @@
 package estelle
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"testing"
@@ -53,7 +54,7 @@ func TestThumbInfo_make(t *testing.T) {
 		t.Errorf("Unexpected path.\nExpected: %s\nActual: %s", expected, path)
 	}
 
-	err = thumbInfo.make()
+	err = thumbInfo.make(context.Background(), DefaultGenerator())
 	if err != nil {
 		t.Fatalf("Failed to make thumbnail: %v", err)
 	}
@@ -0,0 +1,64 @@
+package estelle
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGenerationTimeout(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen), WithGenerationTimeout(100*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer estl.Shutdown(context.Background())
+
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-res.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("generation did not time out")
+	}
+	if !errors.Is(res.Err(), ErrGenerationTimeout) {
+		t.Errorf("expected ErrGenerationTimeout, got %v", res.Err())
+	}
+}
+
+func TestShutdownKillsRunningGenerator(t *testing.T) {
+	tmpDir := t.TempDir()
+	gen := newBlockingGenerator()
+	estl, err := New(filepath.Join(tmpDir, "cache"), WithGenerator(gen))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srcs := makeSources(t, tmpDir, "a.jpg")
+	ti, _ := estl.NewThumbInfo(srcs[0], SizeFromUint(100, 100), ModeCrop, FMT_JPG)
+	res, err := estl.Enqueue(ti)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-gen.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := estl.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	<-res.Done()
+	// The generator must observe the cancellation.
+	select {
+	case <-gen.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("running generator was not cancelled")
+	}
+}
@@ -0,0 +1,155 @@
+package estelle
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// VipsGenerator is a Generator that executes `vipsthumbnail` command.
+// This is the default Generator.
+type VipsGenerator struct {
+	// Command is the name or path of the vipsthumbnail executable.
+	// If empty, "vipsthumbnail" is looked up in $PATH.
+	Command string
+}
+
+// Generate executes vipsthumbnail and blocks until it completes.
+// The output format is determined by vipsthumbnail from the extension of output.
+func (g VipsGenerator) Generate(ctx context.Context, source string, size Size, mode Mode, format Format, output string) error {
+	cmd := exec.CommandContext(ctx, g.command(), vipsArgs(source, size, mode, output)...)
+	// When ctx is done, kill the whole process group, so that no descendant process is left behind.
+	killProcessGroupOnCancel(cmd)
+	cmd.WaitDelay = time.Second
+
+	// Capture stderr for debugging
+	stderr := bytes.NewBuffer([]byte{})
+	cmd.Stderr = stderr
+
+	err := cmd.Run() // block until the command completes.
+	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("vipsthumbnail killed: %w", context.Cause(ctx))
+		}
+		return &GenerationError{
+			Kind:   classify(err, stderr.String()),
+			Source: source,
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    fmt.Errorf("vipsthumbnail failed: %w", err),
+		}
+	}
+	return nil
+}
+
+// Check reports whether the vipsthumbnail executable is found. The error wraps ErrGeneratorMissing.
+func (g VipsGenerator) Check() error {
+	if _, err := exec.LookPath(g.command()); err != nil {
+		return fmt.Errorf("%w: %w", ErrGeneratorMissing, err)
+	}
+	return nil
+}
+
+func (g VipsGenerator) command() string {
+	if g.Command == "" {
+		return "vipsthumbnail"
+	}
+	return g.Command
+}
+
+// GenerateMulti generates the thumbnails for all outputs. If they are much smaller than the source,
+// the source is decoded only once into an intermediate image large enough for all of them, and
+// the thumbnails are generated from it. Otherwise, they are generated one by one from the source.
+func (g VipsGenerator) GenerateMulti(ctx context.Context, source string, outputs []Output) []error {
+	errs := make([]error, len(outputs))
+	size, ok := g.intermediateSize(source, outputs)
+	if !ok {
+		for i, o := range outputs {
+			errs[i] = g.Generate(ctx, source, o.Size, o.Mode, o.Format, o.Path)
+		}
+		return errs
+	}
+
+	// Use vips format for the intermediate, which is lossless and fast to load.
+	intermediate := outputs[0].Path + ".v"
+	defer os.Remove(intermediate)
+	if err := g.Generate(ctx, source, Size{size, size}, ModeShrink, outputs[0].Format, intermediate); err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	for i, o := range outputs {
+		errs[i] = g.Generate(ctx, intermediate, o.Size, o.Mode, o.Format, o.Path)
+		var ge *GenerationError
+		if errors.As(errs[i], &ge) {
+			ge.Source = source // Report the error as if it occurred for the source
+		}
+	}
+	return errs
+}
+
+// intermediateSize returns the bounding box size of the intermediate image from which all outputs
+// can be derived without losing quality. It returns false if the intermediate is not worthwhile,
+// i.e. the source is not large enough compared to the outputs or its size is unknown.
+func (g VipsGenerator) intermediateSize(source string, outputs []Output) (uint, bool) {
+	if len(outputs) < 2 {
+		return 0, false
+	}
+	f, err := os.Open(source)
+	if err != nil {
+		return 0, false
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 {
+		return 0, false
+	}
+	w, h := float64(cfg.Width), float64(cfg.Height)
+	ratio := 0.0 // The largest scale needed by the outputs
+	for _, o := range outputs {
+		// vipsthumbnail may rotate the image according to EXIF orientation, so consider both.
+		for _, dim := range [][2]float64{{w, h}, {h, w}} {
+			sx, sy := float64(o.Size.Width)/dim[0], float64(o.Size.Height)/dim[1]
+			r := math.Max(sx, sy)
+			if o.Mode == ModeShrink {
+				r = math.Min(sx, sy)
+			}
+			ratio = math.Max(ratio, r)
+		}
+	}
+	if ratio >= 0.5 {
+		return 0, false // Decoding the intermediate costs as much as decoding the source
+	}
+	// Add a small margin against rounding errors.
+	return uint(math.Ceil(math.Max(w, h)*ratio)) + 2, true
+}
+
+func vipsArgs(source string, size Size, mode Mode, outputPath string) []string {
+	// vipsthumbnail [flags] sourcefile -o outputfile
+	args := []string{source}
+
+	sizeStr := size.String()
+	// Size logic
+	// vipsthumbnail source.img --size WxH
+	// ModeCrop: --smartcrop=attention
+	// ModeShrink: default
+	// ModeStretch: Postfix "!" to size
+	switch mode {
+	case ModeCrop:
+		args = append(args, "--smartcrop", "attention")
+	case ModeStretch:
+		sizeStr += "!"
+	}
+	args = append(args, "--size", sizeStr)
+
+	args = append(args, "-o", outputPath)
+
+	return args
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package estelle
+
+import (
+	"os/exec"
+)
+
+// killProcessGroupOnCancel does nothing on non-UNIX systems, where process groups are not available.
+// exec.CommandContext kills only the vipsthumbnail process itself.
+func killProcessGroupOnCancel(cmd *exec.Cmd) {}
@@ -0,0 +1,61 @@
+//go:build unix
+
+package estelle
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestVipsGeneratorKillsProcessGroup(t *testing.T) {
+	tmpDir := t.TempDir()
+	pidFile := filepath.Join(tmpDir, "child.pid")
+	// A fake vipsthumbnail which spawns a child process and hangs.
+	script := filepath.Join(tmpDir, "fake-vipsthumbnail")
+	err := os.WriteFile(script, []byte("#!/bin/sh\nsleep 60 &\necho $! > "+pidFile+"\nwait\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeoutCause(context.Background(), 200*time.Millisecond, ErrGenerationTimeout)
+	defer cancel()
+	start := time.Now()
+	err = VipsGenerator{Command: script}.Generate(ctx, "source.jpg", SizeFromUint(100, 100), ModeCrop, FMT_JPG, filepath.Join(tmpDir, "out.jpg"))
+	if !errors.Is(err, ErrGenerationTimeout) {
+		t.Errorf("expected ErrGenerationTimeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Generate took too long after cancellation: %s", elapsed)
+	}
+
+	b, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The child may remain as a zombie for a moment until it is reaped by init.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err := syscall.Kill(pid, 0)
+		if err != nil {
+			break
+		}
+		if stat, _ := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat")); strings.Contains(string(stat), ") Z ") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child process %d is still alive", pid)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}